@@ -3,10 +3,12 @@ module github.com/arl-sh/terraform-provider-git
 go 1.17
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/go-git/go-billy/v5 v5.3.1
 	github.com/go-git/go-git/v5 v5.4.2
 	github.com/hashicorp/terraform-plugin-sdk/v2 v2.10.1
 	golang.org/x/crypto v0.0.0-20220112180741-5e0467b6c7ce
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
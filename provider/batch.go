@@ -0,0 +1,218 @@
+package provider
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	gossh "golang.org/x/crypto/ssh"
+)
+
+// commitBatchDelay is how long a batch waits for other file changes targeting the same branch
+const commitBatchDelay = 500 * time.Millisecond
+
+// fileChange is a change of a single file, either an add item to write or a path to delete
+type fileChange struct {
+	item    map[string]interface{}
+	delete  bool
+	message string
+}
+
+type commitBatch struct {
+	locks   *branchLocks
+	ctx     context.Context
+	url     string
+	branch  string
+	auth    transport.AuthMethod
+	changes []fileChange
+	// Contexts of the callers by change, the changes of callers which gave up are left out
+	contexts []context.Context
+
+	done chan struct{}
+	sha  plumbing.Hash
+	err  error
+	// Errors of the changes which could not be applied by index, the other changes are still committed
+	changeErrs map[int]error
+}
+
+// commitBatcher coalesces file changes targeting the same branch with the same credentials
+// within commitBatchDelay into a single commit
+type commitBatcher struct {
+	mu      sync.Mutex
+	locks   *branchLocks
+	batches map[string]*commitBatch
+}
+
+func newCommitBatcher(locks *branchLocks) *commitBatcher {
+	return &commitBatcher{
+		locks:   locks,
+		batches: map[string]*commitBatch{},
+	}
+}
+
+// Commit queues a file change then waits for the commit containing it to be pushed.
+// The branch tip is returned when the batch did not change anything.
+func (b *commitBatcher) Commit(ctx context.Context, url string, branch string, auth transport.AuthMethod, change fileChange) (plumbing.Hash, error) {
+	// Changes are only pushed together with the credentials each of them was given
+	key := fmt.Sprintf("%s#%s#%s", url, branch, authIdentity(auth))
+
+	// A broken item fails on its own instead of failing the whole batch
+	if !change.delete {
+		err := checkItemContent(change.item)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+
+	b.mu.Lock()
+	batch, ok := b.batches[key]
+	if !ok {
+		batch = &commitBatch{
+			locks: b.locks,
+			// The batch is shared, the cancellation of the caller starting it must not abort it for the others
+			ctx:        context.Background(),
+			url:        url,
+			branch:     branch,
+			auth:       auth,
+			done:       make(chan struct{}),
+			changeErrs: map[int]error{},
+		}
+		b.batches[key] = batch
+
+		time.AfterFunc(commitBatchDelay, func() {
+			// Later changes start a new batch
+			b.mu.Lock()
+			delete(b.batches, key)
+			b.mu.Unlock()
+
+			batch.sha, batch.err = batch.run()
+			close(batch.done)
+		})
+	}
+	index := len(batch.changes)
+	batch.changes = append(batch.changes, change)
+	batch.contexts = append(batch.contexts, ctx)
+	b.mu.Unlock()
+
+	select {
+	case <-batch.done:
+		if err := batch.changeErrs[index]; err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return batch.sha, batch.err
+	case <-ctx.Done():
+		return plumbing.ZeroHash, ctx.Err()
+	}
+}
+
+func (batch *commitBatch) run() (plumbing.Hash, error) {
+	// Wait for other operations on the branch, then start from the tip they leave
+	unlock := batch.locks.Lock(batch.url, batch.branch)
+	defer unlock()
+
+	repo, worktree, sha, err := openBranch(batch.ctx, batch.url, batch.branch, batch.auth, false)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	// Write or delete files, a change failing is reported to its caller only
+	var messages []string
+	for i, change := range batch.changes {
+		if err := batch.contexts[i].Err(); err != nil {
+			batch.changeErrs[i] = err
+			continue
+		}
+
+		if change.delete {
+			path := worktree.Filesystem.Join(change.item["path"].(string))
+			_, err = worktree.Remove(path)
+			if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				batch.changeErrs[i] = fmt.Errorf("failed to delete file %s: %s", path, err)
+				continue
+			}
+		} else {
+			_, err = writeItems(worktree.Filesystem, []interface{}{change.item})
+			if err != nil {
+				batch.changeErrs[i] = err
+				continue
+			}
+		}
+
+		if !containsString(messages, change.message) {
+			messages = append(messages, change.message)
+		}
+	}
+
+	// Commit then push, with distinct messages of the batch joined together
+	commitSha, err := commitAndPush(batch.ctx, repo, worktree, batch.branch, strings.Join(messages, "\n\n"), batch.auth)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	if commitSha.IsZero() {
+		return *sha, nil
+	}
+	batch.locks.RecordPush(batch.url, batch.branch, commitSha, *sha)
+
+	return commitSha, nil
+}
+
+// checkItemContent reads the whole content of an add item, failing when it cannot be decoded
+func checkItemContent(item map[string]interface{}) error {
+	path := item["path"].(string)
+
+	content, err := openItemContent(item)
+	if err != nil {
+		return fmt.Errorf("failed to open content of file %s: %s", path, err)
+	}
+	defer content.Close()
+
+	_, err = io.Copy(io.Discard, content)
+	if err != nil {
+		return fmt.Errorf("failed to read content of file %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// authIdentity returns a digest of the credentials, equal for credentials which can be used interchangeably.
+// Credentials which cannot be compared, such as SSH keys checking known hosts, are only equal to themselves.
+func authIdentity(auth transport.AuthMethod) string {
+	var identity string
+	switch auth := auth.(type) {
+	case nil:
+		return ""
+	case *http.BasicAuth:
+		identity = fmt.Sprintf("basic\x00%s\x00%s", auth.Username, auth.Password)
+	case *http.TokenAuth:
+		identity = fmt.Sprintf("bearer\x00%s", auth.Token)
+	case *ssh.PublicKeys:
+		if auth == nil || auth.HostKeyCallback != nil {
+			return fmt.Sprintf("%p", auth)
+		}
+		identity = fmt.Sprintf("ssh\x00%s\x00%s", auth.User, gossh.FingerprintSHA256(auth.Signer.PublicKey()))
+	default:
+		identity = fmt.Sprintf("%T\x00%#v", auth, auth)
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(identity)))
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,62 @@
+package provider
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+)
+
+func TestAuthIdentity(t *testing.T) {
+	tests := []struct {
+		name  string
+		auth  transport.AuthMethod
+		other transport.AuthMethod
+		equal bool
+	}{
+		{
+			name:  "none",
+			auth:  nil,
+			other: nil,
+			equal: true,
+		},
+		{
+			name:  "same basic credentials",
+			auth:  &http.BasicAuth{Username: "user", Password: "a"},
+			other: &http.BasicAuth{Username: "user", Password: "a"},
+			equal: true,
+		},
+		{
+			name:  "other password",
+			auth:  &http.BasicAuth{Username: "user", Password: "a"},
+			other: &http.BasicAuth{Username: "user", Password: "b"},
+			equal: false,
+		},
+		{
+			name:  "other token",
+			auth:  &http.TokenAuth{Token: "a"},
+			other: &http.TokenAuth{Token: "b"},
+			equal: false,
+		},
+		{
+			name:  "credentials and none",
+			auth:  &http.TokenAuth{Token: "a"},
+			other: nil,
+			equal: false,
+		},
+		{
+			name:  "other method",
+			auth:  &http.BasicAuth{Username: "a"},
+			other: &http.TokenAuth{Token: "a"},
+			equal: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if equal := authIdentity(test.auth) == authIdentity(test.other); equal != test.equal {
+				t.Errorf("expected equal to be %t, got %t", test.equal, equal)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+const (
+	defaultMarkerBegin = "# BEGIN TERRAFORM MANAGED BLOCK"
+	defaultMarkerEnd   = "# END TERRAFORM MANAGED BLOCK"
+)
+
+// findBlock returns the indexes of the marker lines of a block, or -1 when the block is absent
+func findBlock(lines []string, begin string, end string) (int, int, error) {
+	for i, line := range lines {
+		if strings.TrimSuffix(line, "\r") != begin {
+			continue
+		}
+
+		for j := i + 1; j < len(lines); j++ {
+			if strings.TrimSuffix(lines[j], "\r") == end {
+				return i, j, nil
+			}
+		}
+
+		return -1, -1, fmt.Errorf("found %q without %q", begin, end)
+	}
+
+	return -1, -1, nil
+}
+
+// replaceBlock replaces the content between the markers, appending the block to the end of the file when absent
+func replaceBlock(file string, begin string, end string, content string) (string, error) {
+	block := []string{begin}
+	if content != "" {
+		block = append(block, strings.Split(strings.TrimSuffix(content, "\n"), "\n")...)
+	}
+	block = append(block, end)
+
+	lines := strings.Split(file, "\n")
+	i, j, err := findBlock(lines, begin, end)
+	if err != nil {
+		return "", err
+	}
+
+	if i < 0 {
+		if file != "" && !strings.HasSuffix(file, "\n") {
+			file += "\n"
+		}
+		return file + strings.Join(block, "\n") + "\n", nil
+	}
+
+	result := append([]string{}, lines[:i]...)
+	result = append(result, block...)
+	result = append(result, lines[j+1:]...)
+	return strings.Join(result, "\n"), nil
+}
+
+// readBlock returns the content between the markers, and whether the block is present
+func readBlock(file string, begin string, end string) (string, bool, error) {
+	lines := strings.Split(file, "\n")
+	i, j, err := findBlock(lines, begin, end)
+	if err != nil || i < 0 {
+		return "", false, err
+	}
+
+	return strings.Join(lines[i+1:j], "\n"), true, nil
+}
+
+// removeBlock removes the block along with its markers
+func removeBlock(file string, begin string, end string) (string, error) {
+	lines := strings.Split(file, "\n")
+	i, j, err := findBlock(lines, begin, end)
+	if err != nil || i < 0 {
+		return file, err
+	}
+
+	return strings.Join(append(lines[:i], lines[j+1:]...), "\n"), nil
+}
+
+// writeBlocks removes the blocks which are not configured anymore then writes the configured ones
+func writeBlocks(worktree *gogit.Worktree, d resourceGetter) error {
+	oldBlocks, newBlocks := d.GetChange("add_block")
+
+	configured := map[string]bool{}
+	for _, block := range newBlocks.([]interface{}) {
+		configured[blockKey(block.(map[string]interface{}))] = true
+	}
+
+	var removed []interface{}
+	for _, block := range oldBlocks.([]interface{}) {
+		if !configured[blockKey(block.(map[string]interface{}))] {
+			removed = append(removed, block)
+		}
+	}
+
+	err := removeBlocks(worktree, removed)
+	if err != nil {
+		return err
+	}
+
+	for _, block := range newBlocks.([]interface{}) {
+		path := block.(map[string]interface{})["path"].(string)
+
+		err := editFile(worktree.Filesystem, worktree.Filesystem.Join(path), func(file string) (string, error) {
+			return replaceBlock(file, block.(map[string]interface{})["marker_begin"].(string), block.(map[string]interface{})["marker_end"].(string), block.(map[string]interface{})["content"].(string))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeBlocks removes blocks from their file, deleting files left empty
+func removeBlocks(worktree *gogit.Worktree, blocks []interface{}) error {
+	for _, block := range blocks {
+		path := worktree.Filesystem.Join(block.(map[string]interface{})["path"].(string))
+
+		_, err := worktree.Filesystem.Lstat(path)
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+
+		err = editFile(worktree.Filesystem, path, func(file string) (string, error) {
+			return removeBlock(file, block.(map[string]interface{})["marker_begin"].(string), block.(map[string]interface{})["marker_end"].(string))
+		})
+		if err != nil {
+			return err
+		}
+
+		info, err := worktree.Filesystem.Lstat(path)
+		if err != nil {
+			return fmt.Errorf("failed to stat file %s: %s", path, err)
+		}
+		if info.Size() > 0 {
+			continue
+		}
+
+		_, err = worktree.Remove(path)
+		if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+			return fmt.Errorf("failed to delete file %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+// readBlocks returns the blocks with their content read from the files, blocks missing from their file are dropped
+func readBlocks(filesystem billy.Filesystem, blocks []interface{}) ([]interface{}, error) {
+	remoteBlocks := make([]interface{}, 0, len(blocks))
+
+	for _, block := range blocks {
+		path := block.(map[string]interface{})["path"].(string)
+		content := block.(map[string]interface{})["content"].(string)
+
+		file, _, err := readFile(filesystem, filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		remoteContent, ok, err := readBlock(string(file), block.(map[string]interface{})["marker_begin"].(string), block.(map[string]interface{})["marker_end"].(string))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read block of file %s: %s", path, err)
+		}
+		if !ok {
+			continue
+		}
+
+		// A trailing line break of the content is not kept between the markers
+		if strings.HasSuffix(content, "\n") && remoteContent != "" {
+			remoteContent += "\n"
+		}
+
+		remoteBlock := map[string]interface{}{}
+		for key, value := range block.(map[string]interface{}) {
+			remoteBlock[key] = value
+		}
+		remoteBlock["content"] = remoteContent
+
+		remoteBlocks = append(remoteBlocks, remoteBlock)
+	}
+
+	return remoteBlocks, nil
+}
+
+// blockKey identifies a block by its file and its markers
+func blockKey(block map[string]interface{}) string {
+	return fmt.Sprintf("%s#%s#%s", block["path"], block["marker_begin"], block["marker_end"])
+}
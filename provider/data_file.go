@@ -2,15 +2,16 @@ package provider
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
 	"path/filepath"
 
 	"github.com/go-git/go-billy/v5/memfs"
 	gogit "github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
@@ -37,11 +38,28 @@ func dataFile() *schema.Resource {
 				Type:     schema.TypeString,
 				Required: true,
 			},
+			"recurse_submodules": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
 
 			"content": {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
+			"content_base64": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"mode": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"symlink_target": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
 		},
 	}
 }
@@ -91,26 +109,28 @@ func dataFileRead(ctx context.Context, d *schema.ResourceData, meta interface{})
 		}
 	}
 
-	// Open, read then close file
-	file, err := worktree.Filesystem.Open(path)
+	// Read file, looking into submodules when not found in the repository itself
+	content, mode, err := readFile(worktree.Filesystem, path)
+	if err != nil && errors.Is(err, fs.ErrNotExist) && d.Get("recurse_submodules").(bool) {
+		content, mode, err = readSubmoduleFile(ctx, meta.(*providerConfig).sources, repo, url, path, auth)
+	}
 	if err != nil && errors.Is(err, fs.ErrNotExist) {
 		d.SetId("")
 		return nil
 	} else if err != nil {
-		return diag.Errorf("failed to open file: %s", err)
+		return diag.FromErr(err)
 	}
 
 	d.SetId(filepath.Join(url, path))
-
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return diag.Errorf("failed to read file: %s", err)
-	}
 	d.Set("content", string(content))
-
-	err = file.Close()
-	if err != nil {
-		return diag.Errorf("failed to close file: %s", err)
+	d.Set("content_base64", base64.StdEncoding.EncodeToString(content))
+	d.Set("mode", fmt.Sprintf("%o", uint32(mode)))
+
+	// Symlinks are stored by Git as a blob containing their target
+	if mode == filemode.Symlink {
+		d.Set("symlink_target", string(content))
+	} else {
+		d.Set("symlink_target", "")
 	}
 
 	return nil
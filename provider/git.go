@@ -0,0 +1,296 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/utils/merkletrie"
+)
+
+// cloneBranch clones a repository in memory then checks out the tip of a branch
+func cloneBranch(ctx context.Context, url string, branch string, auth transport.AuthMethod) (*gogit.Repository, *gogit.Worktree, *plumbing.Hash, error) {
+	repo, err := gogit.CloneContext(ctx, memory.NewStorage(), memfs.New(), &gogit.CloneOptions{
+		URL:  url,
+		Auth: auth,
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to clone repository: %w", err)
+	}
+
+	// Get the current worktree
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	// Resolve then checkout the specified branch
+	sha, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName("origin", branch)))
+	if err != nil && errors.Is(err, plumbing.ErrReferenceNotFound) {
+		sha, err = repo.ResolveRevision(plumbing.Revision(plumbing.NewBranchReferenceName(branch)))
+	}
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to resolve branch %s: %w", branch, err)
+	}
+
+	err = worktree.Checkout(&gogit.CheckoutOptions{
+		Hash:  *sha,
+		Force: true,
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to checkout hash %s: %w", sha.String(), err)
+	}
+
+	return repo, worktree, sha, nil
+}
+
+// openBranch clones a repository then checks out the tip of a branch to commit on top of it.
+// Empty repositories, and missing branches when orphan is set, start from an empty worktree
+// and a zero tip so that the next commit has no parent.
+func openBranch(ctx context.Context, url string, branch string, auth transport.AuthMethod, orphan bool) (*gogit.Repository, *gogit.Worktree, *plumbing.Hash, error) {
+	repo, worktree, sha, err := cloneBranch(ctx, url, branch, auth)
+	if err == nil || !(errors.Is(err, transport.ErrEmptyRemoteRepository) || orphan && errors.Is(err, plumbing.ErrReferenceNotFound)) {
+		return repo, worktree, sha, err
+	}
+
+	repo, err = gogit.Init(memory.NewStorage(), memfs.New())
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to init repository: %w", err)
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to create remote: %w", err)
+	}
+
+	// Point HEAD to the unborn branch
+	err = repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branch)))
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to set HEAD: %w", err)
+	}
+
+	worktree, err = repo.Worktree()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	return repo, worktree, &plumbing.Hash{}, nil
+}
+
+// tipString returns the SHA of a branch tip, or an empty string for a branch to be created
+func tipString(sha plumbing.Hash) string {
+	if sha.IsZero() {
+		return ""
+	}
+
+	return sha.String()
+}
+
+// isBranchNotFound returns whether an error is caused by a missing repository or branch
+func isBranchNotFound(err error) bool {
+	return errors.Is(err, transport.ErrRepositoryNotFound) ||
+		errors.Is(err, transport.ErrEmptyRemoteRepository) ||
+		errors.Is(err, plumbing.ErrReferenceNotFound)
+}
+
+// commitAndPush stages the whole worktree, commits it then pushes the commit to the branch.
+// A zero hash is returned when the worktree is clean.
+func commitAndPush(ctx context.Context, repo *gogit.Repository, worktree *gogit.Worktree, branch string, message string, auth transport.AuthMethod) (plumbing.Hash, error) {
+	commitSha, err := commitWorktree(worktree, message)
+	if err != nil || commitSha.IsZero() {
+		return commitSha, err
+	}
+
+	err = pushBranch(ctx, repo, branch, commitSha, auth)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return commitSha, nil
+}
+
+// commitWorktree stages the whole worktree then commits it.
+// A zero hash is returned when the worktree is clean.
+func commitWorktree(worktree *gogit.Worktree, message string) (plumbing.Hash, error) {
+	// Check if worktree is clean
+	status, err := worktree.Status()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to compute worktree status: %w", err)
+	}
+	if status.IsClean() {
+		return plumbing.ZeroHash, nil
+	}
+
+	// Stage worktree
+	err = worktree.AddWithOptions(&gogit.AddOptions{
+		All: true,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to stage worktree: %w", err)
+	}
+
+	// Commit
+	commitSha, err := worktree.Commit(message, &gogit.CommitOptions{
+		Author: &object.Signature{
+			Name:  os.Getenv("GIT_AUTHOR_NAME"),
+			Email: os.Getenv("GIT_AUTHOR_EMAIL"),
+			When:  time.Now(),
+		},
+	})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to commit: %w", err)
+	}
+
+	return commitSha, nil
+}
+
+// pushBranch points the branch to a commit then pushes it
+func pushBranch(ctx context.Context, repo *gogit.Repository, branch string, commitSha plumbing.Hash, auth transport.AuthMethod) error {
+	// Update branch
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	hashRef := plumbing.NewHashReference(branchRef, commitSha)
+	err := repo.Storer.SetReference(hashRef)
+	if err != nil {
+		return fmt.Errorf("failed to set branch ref: %w", err)
+	}
+
+	// Push
+	err = repo.PushContext(ctx, &gogit.PushOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("%s:%s", branchRef, branchRef)),
+		},
+		Auth: auth,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to push: %w", err)
+	}
+
+	return nil
+}
+
+// listChanges lists the files changed by a commit compared to its first parent.
+// A deleted and an added file sharing the same content are reported as a rename, like Git does.
+func listChanges(repo *gogit.Repository, commitSha plumbing.Hash) ([]map[string]interface{}, error) {
+	commit, err := repo.CommitObject(commitSha)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", commitSha.String(), err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of commit %s: %w", commitSha.String(), err)
+	}
+
+	parentTree := &object.Tree{}
+	if commit.NumParents() > 0 {
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get parent of commit %s: %w", commitSha.String(), err)
+		}
+
+		parentTree, err = parent.Tree()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get tree of commit %s: %w", parent.Hash.String(), err)
+		}
+	}
+
+	return diffTrees(parentTree, tree)
+}
+
+func diffTrees(from *object.Tree, to *object.Tree) ([]map[string]interface{}, error) {
+	treeChanges, err := object.DiffTree(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff trees: %w", err)
+	}
+
+	// Count added and deleted lines of each file
+	patch, err := treeChanges.Patch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute patch: %w", err)
+	}
+	stats := map[string]object.FileStat{}
+	for _, stat := range patch.Stats() {
+		stats[stat.Name] = stat
+	}
+
+	var changes []map[string]interface{}
+	deleted := map[plumbing.Hash][]int{}
+	for _, treeChange := range treeChanges {
+		action, err := treeChange.Action()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get change action: %w", err)
+		}
+
+		switch action {
+		case merkletrie.Insert:
+			changes = append(changes, map[string]interface{}{
+				"action":        "added",
+				"path":          treeChange.To.Name,
+				"previous_path": "",
+				"additions":     stats[treeChange.To.Name].Addition,
+				"deletions":     stats[treeChange.To.Name].Deletion,
+			})
+		case merkletrie.Delete:
+			deleted[treeChange.From.TreeEntry.Hash] = append(deleted[treeChange.From.TreeEntry.Hash], len(changes))
+			changes = append(changes, map[string]interface{}{
+				"action":        "deleted",
+				"path":          treeChange.From.Name,
+				"previous_path": "",
+				"additions":     stats[treeChange.From.Name].Addition,
+				"deletions":     stats[treeChange.From.Name].Deletion,
+			})
+		case merkletrie.Modify:
+			changes = append(changes, map[string]interface{}{
+				"action":        "modified",
+				"path":          treeChange.To.Name,
+				"previous_path": "",
+				"additions":     stats[treeChange.To.Name].Addition,
+				"deletions":     stats[treeChange.To.Name].Deletion,
+			})
+		}
+	}
+
+	// Pair added files with deleted files of identical content
+	renamed := map[int]bool{}
+	for i, treeChange := range treeChanges {
+		if changes[i]["action"] != "added" {
+			continue
+		}
+
+		hash := treeChange.To.TreeEntry.Hash
+		if len(deleted[hash]) == 0 {
+			continue
+		}
+
+		from := deleted[hash][0]
+		deleted[hash] = deleted[hash][1:]
+		renamed[from] = true
+
+		// Content is identical so no lines changed
+		changes[i]["action"] = "renamed"
+		changes[i]["previous_path"] = changes[from]["path"]
+		changes[i]["additions"] = 0
+		changes[i]["deletions"] = 0
+	}
+
+	result := make([]map[string]interface{}, 0, len(changes))
+	for i, change := range changes {
+		if !renamed[i] {
+			result = append(result, change)
+		}
+	}
+
+	return result, nil
+}
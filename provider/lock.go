@@ -0,0 +1,498 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// remoteLockPollInterval is how often a lock ref held by someone else is checked while waiting for it
+const remoteLockPollInterval = 2 * time.Second
+
+// minRemoteLockTTL is the shortest ttl of a lock, which is refreshed every third of it
+const minRemoteLockTTL = 10 * time.Second
+
+// branchLocks serializes operations targeting the same branch within the provider,
+// and remembers the commits pushed by the provider to each branch
+type branchLocks struct {
+	mu     sync.Mutex
+	locks  map[string]*sync.Mutex
+	pushed map[string]map[plumbing.Hash]plumbing.Hash
+}
+
+func newBranchLocks() *branchLocks {
+	return &branchLocks{
+		locks:  map[string]*sync.Mutex{},
+		pushed: map[string]map[plumbing.Hash]plumbing.Hash{},
+	}
+}
+
+// Lock waits until no other operation targets the ref, then returns a function releasing it
+func (l *branchLocks) Lock(url string, ref string) func() {
+	key := fmt.Sprintf("%s#%s", url, ref)
+
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		l.locks[key] = lock
+	}
+	l.mu.Unlock()
+
+	lock.Lock()
+	return lock.Unlock
+}
+
+// RecordPush remembers that a commit on top of parent was pushed to the branch
+func (l *branchLocks) RecordPush(url string, branch string, commitSha plumbing.Hash, parent plumbing.Hash) {
+	key := fmt.Sprintf("%s#%s", url, branch)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.pushed[key] == nil {
+		l.pushed[key] = map[plumbing.Hash]plumbing.Hash{}
+	}
+	l.pushed[key][commitSha] = parent
+}
+
+// PushedSince returns whether the branch only moved from one commit to another
+// through commits pushed by the provider
+func (l *branchLocks) PushedSince(url string, branch string, from plumbing.Hash, to plumbing.Hash) bool {
+	key := fmt.Sprintf("%s#%s", url, branch)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for to != from {
+		parent, ok := l.pushed[key][to]
+		if !ok {
+			return false
+		}
+		to = parent
+	}
+
+	return true
+}
+
+// remoteLockHolder is the metadata stored as JSON in the message of the commit a lock ref points to
+type remoteLockHolder struct {
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// remoteLock is a lock shared with other workspaces, held by creating a ref in the remote repository
+type remoteLock struct {
+	repo *gogit.Repository
+	ref  plumbing.ReferenceName
+	sha  plumbing.Hash
+	auth transport.AuthMethod
+
+	// Stops refreshing the lock, done is closed once stopped
+	stop context.CancelFunc
+	done chan struct{}
+	// Last error refreshing the lock, cleared by the next successful refresh
+	refreshErr error
+}
+
+// acquireRemoteLock creates the lock ref refs/locks/<name> pointing to a parentless commit describing the holder.
+// The ref is taken over once expired, and waited for until timeout while held by someone else.
+func acquireRemoteLock(ctx context.Context, url string, auth transport.AuthMethod, name string, holder string, ttl time.Duration, timeout time.Duration) (*remoteLock, error) {
+	if holder == "" {
+		hostname, _ := os.Hostname()
+		holder = fmt.Sprintf("%s (pid %d)", hostname, os.Getpid())
+	}
+
+	repo, err := gogit.Init(memory.NewStorage(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to init repository: %w", err)
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote: %w", err)
+	}
+
+	lock := &remoteLock{
+		repo: repo,
+		ref:  plumbing.ReferenceName("refs/locks/" + name),
+		auth: auth,
+	}
+	deadline := time.Now().Add(timeout)
+
+	var pushErr error
+	for {
+		current, currentHolder, err := lock.read(ctx, remote)
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		expired := currentHolder != nil && !currentHolder.ExpiresAt.IsZero() && now.After(currentHolder.ExpiresAt)
+
+		// A push failing while nobody else holds the lock is not a race with another holder
+		if pushErr != nil && (current == nil || expired) {
+			return nil, fmt.Errorf("failed to create lock ref %s: %w", lock.ref, pushErr)
+		}
+
+		if current == nil || expired {
+			metadata := remoteLockHolder{
+				Holder:     holder,
+				AcquiredAt: now.UTC(),
+				ExpiresAt:  now.Add(ttl).UTC(),
+			}
+			lock.sha, err = lock.commit(metadata)
+			if err != nil {
+				return nil, err
+			}
+
+			options := &gogit.PushOptions{
+				RefSpecs: []config.RefSpec{
+					config.RefSpec(fmt.Sprintf("%s:%s", lock.ref, lock.ref)),
+				},
+				Auth: auth,
+			}
+			if current != nil {
+				// Only take over the expired holder, not someone who took it over in between
+				options.Force = true
+				options.RequireRemoteRefs = []config.RefSpec{
+					config.RefSpec(fmt.Sprintf("%s:%s", current.Hash(), lock.ref)),
+				}
+			}
+
+			pushErr = remote.PushContext(ctx, options)
+			if pushErr == nil {
+				lock.keepAlive(metadata, ttl)
+				return lock, nil
+			}
+
+			continue
+		}
+
+		if !now.Before(deadline) {
+			return nil, &remoteLockHeldError{ref: lock.ref, holder: currentHolder}
+		}
+
+		// Wait for the holder to release the lock
+		wait := remoteLockPollInterval
+		if remaining := deadline.Sub(now); remaining < wait {
+			wait = remaining
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// keepAlive pushes the lock again with a later expiry every third of its ttl, so that a long
+// operation does not outlive its lock. The lock is only refreshed while it still points to the last push.
+func (l *remoteLock) keepAlive(holder remoteLockHolder, ttl time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.stop = cancel
+	l.done = make(chan struct{})
+
+	interval := ttl / 3
+	go func() {
+		defer close(l.done)
+		if interval <= 0 {
+			return
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			// A refresh is not interrupted, the lock ref would be left unknown to Release
+			holder.ExpiresAt = time.Now().Add(ttl).UTC()
+			l.refreshErr = l.refresh(context.Background(), holder)
+		}
+	}()
+}
+
+// refresh replaces the lock commit with one describing the holder, unless someone else took the lock over
+func (l *remoteLock) refresh(ctx context.Context, holder remoteLockHolder) error {
+	sha, err := l.commit(holder)
+	if err != nil {
+		return err
+	}
+
+	err = l.repo.PushContext(ctx, &gogit.PushOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("%s:%s", l.ref, l.ref)),
+		},
+		RequireRemoteRefs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("%s:%s", l.sha, l.ref)),
+		},
+		Force: true,
+		Auth:  l.auth,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to refresh lock ref %s: %w", l.ref, err)
+	}
+
+	l.sha = sha
+	return nil
+}
+
+// Release stops refreshing the lock then deletes the lock ref, unless it has been taken over by someone else after expiring
+func (l *remoteLock) Release(ctx context.Context) error {
+	l.stop()
+	<-l.done
+
+	err := l.repo.PushContext(ctx, &gogit.PushOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf(":%s", l.ref)),
+		},
+		RequireRemoteRefs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("%s:%s", l.sha, l.ref)),
+		},
+		Auth: l.auth,
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) && l.refreshErr != nil {
+		return fmt.Errorf("failed to delete lock ref %s: %w, the lock may have been taken over after the last refresh failed: %s", l.ref, err, l.refreshErr)
+	} else if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to delete lock ref %s: %w", l.ref, err)
+	}
+
+	return nil
+}
+
+// read returns the lock ref along with its holder, or nil when the lock is free
+func (l *remoteLock) read(ctx context.Context, remote *gogit.Remote) (*plumbing.Reference, *remoteLockHolder, error) {
+	refs, err := remote.ListContext(ctx, &gogit.ListOptions{
+		Auth: l.auth,
+	})
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	var current *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name() == l.ref {
+			current = ref
+		}
+	}
+	if current == nil {
+		return nil, nil, nil
+	}
+
+	err = remote.FetchContext(ctx, &gogit.FetchOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("+%s:%s", l.ref, l.ref)),
+		},
+		Depth: 1,
+		Auth:  l.auth,
+	})
+	if err != nil && !errors.Is(err, gogit.NoErrAlreadyUpToDate) {
+		return nil, nil, fmt.Errorf("failed to fetch lock ref %s: %w", l.ref, err)
+	}
+
+	commit, err := l.repo.CommitObject(current.Hash())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get commit %s: %w", current.Hash().String(), err)
+	}
+
+	// A lock ref without metadata never expires
+	holder := &remoteLockHolder{}
+	if err := json.Unmarshal([]byte(commit.Message), holder); err != nil {
+		holder = &remoteLockHolder{Holder: commit.Message}
+	}
+
+	return current, holder, nil
+}
+
+// commit creates a parentless commit of an empty tree describing the holder, then points the local lock ref to it
+func (l *remoteLock) commit(holder remoteLockHolder) (plumbing.Hash, error) {
+	message, err := json.Marshal(holder)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to encode lock holder: %w", err)
+	}
+
+	tree := l.repo.Storer.NewEncodedObject()
+	err = (&object.Tree{}).Encode(tree)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to encode tree: %w", err)
+	}
+	treeSha, err := l.repo.Storer.SetEncodedObject(tree)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store tree: %w", err)
+	}
+
+	signature := object.Signature{
+		Name:  os.Getenv("GIT_AUTHOR_NAME"),
+		Email: os.Getenv("GIT_AUTHOR_EMAIL"),
+		When:  holder.AcquiredAt,
+	}
+	commit := l.repo.Storer.NewEncodedObject()
+	err = (&object.Commit{
+		Author:    signature,
+		Committer: signature,
+		Message:   string(message),
+		TreeHash:  treeSha,
+	}).Encode(commit)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to encode commit: %w", err)
+	}
+	commitSha, err := l.repo.Storer.SetEncodedObject(commit)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store commit: %w", err)
+	}
+
+	err = l.repo.Storer.SetReference(plumbing.NewHashReference(l.ref, commitSha))
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to set lock ref: %w", err)
+	}
+
+	return commitSha, nil
+}
+
+// remoteLockHeldError is returned when the lock ref is still held by someone else after the timeout
+type remoteLockHeldError struct {
+	ref    plumbing.ReferenceName
+	holder *remoteLockHolder
+}
+
+func (e *remoteLockHeldError) Error() string {
+	if e.holder.ExpiresAt.IsZero() {
+		return fmt.Sprintf("lock ref %s is held by %s", e.ref, e.holder.Holder)
+	}
+
+	return fmt.Sprintf("lock ref %s is held by %s since %s until %s", e.ref, e.holder.Holder, e.holder.AcquiredAt.Format(time.RFC3339), e.holder.ExpiresAt.Format(time.RFC3339))
+}
+
+// lockBranch holds the lock ref configured in lock, if any, then the in-process lock of the branch.
+// The returned function releases both.
+func lockBranch(ctx context.Context, d *schema.ResourceData, locks *branchLocks, url string, branch string, auth transport.AuthMethod) (func(context.Context) diag.Diagnostics, diag.Diagnostics) {
+	lockItems := d.Get("lock").([]interface{})
+	if len(lockItems) == 0 || lockItems[0] == nil {
+		unlock := locks.Lock(url, branch)
+		return func(context.Context) diag.Diagnostics {
+			unlock()
+			return nil
+		}, nil
+	}
+
+	lockItem := lockItems[0].(map[string]interface{})
+	name := lockItem["name"].(string)
+	ttl, _ := time.ParseDuration(lockItem["ttl"].(string))
+	timeout, _ := time.ParseDuration(lockItem["timeout"].(string))
+
+	// Resources of the same apply sharing the lock ref take turns instead of competing for it
+	ref := fmt.Sprintf("refs/locks/%s", name)
+	unlockRef := locks.Lock(url, ref)
+
+	remoteLock, err := acquireRemoteLock(ctx, url, auth, name, lockItem["holder"].(string), ttl, timeout)
+	if err != nil && errors.Is(err, transport.ErrRepositoryNotFound) {
+		// A missing repository has nothing to protect, the operation reports it
+		unlockRef()
+		unlockBranch := locks.Lock(url, branch)
+		return func(context.Context) diag.Diagnostics {
+			unlockBranch()
+			return nil
+		}, nil
+	} else if err != nil {
+		unlockRef()
+
+		var heldErr *remoteLockHeldError
+		if errors.As(err, &heldErr) {
+			return nil, diag.Diagnostics{
+				{
+					Severity: diag.Error,
+					Summary:  fmt.Sprintf("Lock %s is held", name),
+					Detail:   fmt.Sprintf("The %s. Increase lock.timeout to wait for it, or delete the ref once its holder is known to be gone.", heldErr.Error()),
+				},
+			}
+		}
+		return nil, diag.FromErr(err)
+	}
+
+	unlockBranch := locks.Lock(url, branch)
+	return func(ctx context.Context) diag.Diagnostics {
+		defer unlockRef()
+		unlockBranch()
+
+		if err := remoteLock.Release(ctx); err != nil {
+			return diag.Diagnostics{
+				{
+					Severity: diag.Warning,
+					Summary:  fmt.Sprintf("Failed to release lock %s", name),
+					Detail:   fmt.Sprintf("%s. The lock expires after its ttl, or the ref can be deleted manually.", err),
+				},
+			}
+		}
+		return nil
+	}, nil
+}
+
+func lockSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"name": {
+					Type:         schema.TypeString,
+					Required:     true,
+					ValidateFunc: validation.StringMatch(regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*(/[A-Za-z0-9_-][A-Za-z0-9._-]*)*$`), "must be a valid ref name"),
+				},
+				"holder": {
+					Type:     schema.TypeString,
+					Optional: true,
+				},
+				"ttl": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "10m",
+					ValidateFunc: validateLockTTL,
+				},
+				"timeout": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      "0s",
+					ValidateFunc: validateDuration,
+				},
+			},
+		},
+	}
+}
+
+// validateLockTTL checks a lock ttl is a duration long enough for the lock to be refreshed before it expires
+func validateLockTTL(value interface{}, key string) ([]string, []error) {
+	warnings, errs := validateDuration(value, key)
+	if len(errs) > 0 {
+		return warnings, errs
+	}
+
+	ttl, _ := time.ParseDuration(value.(string))
+	if ttl < minRemoteLockTTL {
+		return warnings, []error{fmt.Errorf("expected %s to be at least %s, got %s", key, minRemoteLockTTL, value.(string))}
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,207 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+func TestValidateLockTTL(t *testing.T) {
+	tests := []struct {
+		value string
+		valid bool
+	}{
+		{value: "0s", valid: false},
+		{value: "-1m", valid: false},
+		{value: "5s", valid: false},
+		{value: "10s", valid: true},
+		{value: "10m", valid: true},
+		{value: "bad", valid: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			_, errs := validateLockTTL(test.value, "ttl")
+			if valid := len(errs) == 0; valid != test.valid {
+				t.Errorf("expected valid to be %t, got %v", test.valid, errs)
+			}
+		})
+	}
+}
+
+func TestAcquireRemoteLock(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve file:// repositories")
+	}
+
+	tests := []struct {
+		name string
+		// Holder of the lock ref before acquiring it, if any
+		holder *remoteLockHolder
+		ttl    time.Duration
+		held   bool
+	}{
+		{
+			name: "free",
+			ttl:  time.Minute,
+		},
+		{
+			name:   "held",
+			holder: &remoteLockHolder{Holder: "other", ExpiresAt: time.Now().Add(time.Hour).UTC()},
+			ttl:    time.Minute,
+			held:   true,
+		},
+		{
+			name:   "expired",
+			holder: &remoteLockHolder{Holder: "other", ExpiresAt: time.Now().Add(-time.Minute).UTC()},
+			ttl:    time.Minute,
+		},
+		{
+			name:   "without expiry",
+			holder: &remoteLockHolder{Holder: "other"},
+			ttl:    time.Minute,
+			held:   true,
+		},
+		{
+			name:   "acquired with a zero ttl",
+			holder: &remoteLockHolder{Holder: "other", AcquiredAt: time.Now().UTC(), ExpiresAt: time.Now().UTC()},
+			ttl:    time.Minute,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			url := newBareRepository(t)
+			if test.holder != nil {
+				pushLockRef(t, url, "test", *test.holder)
+			}
+
+			lock, err := acquireRemoteLock(ctx, url, nil, "test", "me", test.ttl, 0)
+			if test.held {
+				var heldErr *remoteLockHeldError
+				if !errors.As(err, &heldErr) {
+					t.Fatalf("expected the lock to be held, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			if sha := remoteRef(t, url, "refs/locks/test"); sha != lock.sha {
+				t.Errorf("expected the lock ref to point to %s, got %s", lock.sha, sha)
+			}
+			if err := lock.Release(ctx); err != nil {
+				t.Fatalf("unexpected error releasing the lock: %s", err)
+			}
+			if sha := remoteRef(t, url, "refs/locks/test"); !sha.IsZero() {
+				t.Errorf("expected the lock ref to be deleted, got %s", sha)
+			}
+		})
+	}
+}
+
+func TestRemoteLockTakenOver(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is required to serve file:// repositories")
+	}
+
+	ctx := context.Background()
+	url := newBareRepository(t)
+
+	// A lock acquired with a zero ttl expires right away
+	first, err := acquireRemoteLock(ctx, url, nil, "test", "first", 0, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second, err := acquireRemoteLock(ctx, url, nil, "test", "second", time.Minute, 0)
+	if err != nil {
+		t.Fatalf("expected the expired lock to be taken over, got %s", err)
+	}
+
+	if err := first.refresh(ctx, remoteLockHolder{Holder: "first"}); err == nil {
+		t.Errorf("expected refreshing a lock taken over to fail")
+	}
+	if err := first.Release(ctx); err == nil {
+		t.Errorf("expected releasing a lock taken over to fail")
+	}
+	if sha := remoteRef(t, url, "refs/locks/test"); sha != second.sha {
+		t.Errorf("expected the lock ref to still point to %s, got %s", second.sha, sha)
+	}
+
+	if err := second.Release(ctx); err != nil {
+		t.Fatalf("unexpected error releasing the lock: %s", err)
+	}
+}
+
+// newBareRepository creates an empty bare repository and returns its URL
+func newBareRepository(t *testing.T) string {
+	dir := t.TempDir()
+	if _, err := gogit.PlainInit(dir, true); err != nil {
+		t.Fatalf("failed to init repository: %s", err)
+	}
+
+	return fmt.Sprintf("file://%s", dir)
+}
+
+// pushLockRef points the lock ref refs/locks/<name> to a commit describing the holder
+func pushLockRef(t *testing.T, url string, name string, holder remoteLockHolder) {
+	repo, err := gogit.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatalf("failed to init repository: %s", err)
+	}
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	if err != nil {
+		t.Fatalf("failed to create remote: %s", err)
+	}
+
+	lock := &remoteLock{
+		repo: repo,
+		ref:  plumbing.ReferenceName("refs/locks/" + name),
+	}
+	if _, err := lock.commit(holder); err != nil {
+		t.Fatal(err)
+	}
+
+	err = repo.Push(&gogit.PushOptions{
+		RefSpecs: []config.RefSpec{
+			config.RefSpec(fmt.Sprintf("%s:%s", lock.ref, lock.ref)),
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to push lock ref: %s", err)
+	}
+}
+
+// remoteRef returns the commit a ref of the remote repository points to, or the zero hash when it is missing
+func remoteRef(t *testing.T, url string, name plumbing.ReferenceName) plumbing.Hash {
+	remote := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	refs, err := remote.List(&gogit.ListOptions{})
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		t.Fatalf("failed to list refs: %s", err)
+	}
+
+	for _, ref := range refs {
+		if ref.Name() == name {
+			return ref.Hash()
+		}
+	}
+
+	return plumbing.ZeroHash
+}
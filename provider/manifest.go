@@ -0,0 +1,270 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// defaultManifestPath is where the ownership manifest is kept when not configured
+const defaultManifestPath = ".terraform-git-managed.json"
+
+// ownershipManifests edits ownership manifests, mapping managed paths to their owner, within a worktree
+type ownershipManifests struct {
+	worktree  *gogit.Worktree
+	manifests map[string]map[string]string
+}
+
+func newOwnershipManifests(worktree *gogit.Worktree) *ownershipManifests {
+	return &ownershipManifests{
+		worktree:  worktree,
+		manifests: map[string]map[string]string{},
+	}
+}
+
+// Claim records the owner of paths, failing when one is already owned by someone else
+func (m *ownershipManifests) Claim(manifestPath string, owner string, paths []string) error {
+	manifest, err := m.read(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if current, ok := manifest[path]; ok && current != owner {
+			return fmt.Errorf("path %s is owned by %s according to %s, it cannot also be managed by %s", path, current, manifestPath, owner)
+		}
+	}
+	for _, path := range paths {
+		manifest[path] = owner
+	}
+
+	return nil
+}
+
+// Release forgets the paths still owned by the owner
+func (m *ownershipManifests) Release(manifestPath string, owner string, paths []string) error {
+	manifest, err := m.read(manifestPath)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if manifest[path] == owner {
+			delete(manifest, path)
+		}
+	}
+
+	return nil
+}
+
+// Write writes the edited manifests back to the worktree, deleting empty ones
+func (m *ownershipManifests) Write() error {
+	for manifestPath, manifest := range m.manifests {
+		if len(manifest) == 0 {
+			_, err := m.worktree.Remove(manifestPath)
+			if err != nil && !errors.Is(err, index.ErrEntryNotFound) && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to delete manifest %s: %s", manifestPath, err)
+			}
+			continue
+		}
+
+		// Keys are sorted so that unchanged manifests produce no diff
+		content, err := json.MarshalIndent(manifest, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode manifest %s: %s", manifestPath, err)
+		}
+		content = append(content, '\n')
+
+		err = writeFile(m.worktree.Filesystem, manifestPath, bytes.NewReader(content), 0644)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *ownershipManifests) read(manifestPath string) (map[string]string, error) {
+	if manifest, ok := m.manifests[manifestPath]; ok {
+		return manifest, nil
+	}
+
+	manifest := map[string]string{}
+	content, _, err := readFile(m.worktree.Filesystem, manifestPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read manifest %s: %s", manifestPath, err)
+	}
+	if err == nil {
+		err = json.Unmarshal(content, &manifest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest %s: %s", manifestPath, err)
+		}
+	}
+
+	m.manifests[manifestPath] = manifest
+	return manifest, nil
+}
+
+// getOwnership returns the manifest path and owner of an ownership block, or empty strings when unset
+func getOwnership(value interface{}) (string, string) {
+	items := value.([]interface{})
+	if len(items) == 0 || items[0] == nil {
+		return "", ""
+	}
+
+	item := items[0].(map[string]interface{})
+	return item["manifest_path"].(string), item["owner"].(string)
+}
+
+// managedPaths lists the paths written by add items and mirrored directories
+func managedPaths(items []interface{}, sourceHashes map[string]interface{}) []string {
+	set := map[string]bool{}
+	for _, item := range items {
+		set[item.(map[string]interface{})["path"].(string)] = true
+	}
+	for path := range sourceHashes {
+		set[path] = true
+	}
+
+	paths := make([]string, 0, len(set))
+	for path := range set {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// editedPaths lists the paths of files edited in place by the resource, as last applied when old is set or as planned
+func editedPaths(d resourceGetter, old bool) []string {
+	get := func(key string) interface{} {
+		oldValue, newValue := d.GetChange(key)
+		if old {
+			return oldValue
+		}
+		return newValue
+	}
+
+	set := map[string]bool{}
+	for _, key := range []string{"set_value", "replace", "submodule"} {
+		for _, item := range get(key).([]interface{}) {
+			set[item.(map[string]interface{})["path"].(string)] = true
+		}
+	}
+
+	// The patch is validated when configured, renamed files are edited at both paths
+	files, _ := parsePatch(get("patch").(string))
+	for _, file := range files {
+		for _, path := range []string{file.oldPath, file.newPath} {
+			if path != "" {
+				set[path] = true
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(set))
+	for path := range set {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+// claimedPaths lists every path written or edited by the resource, as last applied when old is set or as planned
+func claimedPaths(d resourceGetter, old bool, sourceHashes map[string]interface{}) []string {
+	oldItems, items := d.GetChange("add")
+	oldBlocks, blocks := d.GetChange("add_block")
+	if old {
+		items, blocks = oldItems, oldBlocks
+	}
+
+	paths := append(managedPaths(items.(*schema.Set).List(), sourceHashes), editedPaths(d, old)...)
+	for _, block := range blocks.([]interface{}) {
+		paths = append(paths, block.(map[string]interface{})["path"].(string))
+	}
+
+	return paths
+}
+
+// updateManifest releases the paths previously managed by the resource then claims the current ones
+func updateManifest(worktree *gogit.Worktree, d resourceGetter, sourceHashes map[string]interface{}) error {
+	oldHashes, _ := d.GetChange("source_sha256")
+
+	return updateOwnership(worktree, d, claimedPaths(d, true, oldHashes.(map[string]interface{})), claimedPaths(d, false, sourceHashes))
+}
+
+// updateOwnership releases old paths from the manifest of the previous ownership block then claims new paths
+func updateOwnership(worktree *gogit.Worktree, d resourceGetter, oldPaths []string, newPaths []string) error {
+	oldOwnership, newOwnership := d.GetChange("ownership")
+	oldManifestPath, oldOwner := getOwnership(oldOwnership)
+	newManifestPath, newOwner := getOwnership(newOwnership)
+	if oldOwner == "" && newOwner == "" {
+		return nil
+	}
+
+	manifests := newOwnershipManifests(worktree)
+
+	if oldOwner != "" {
+		err := manifests.Release(oldManifestPath, oldOwner, oldPaths)
+		if err != nil {
+			return err
+		}
+	}
+
+	if newOwner != "" {
+		err := manifests.Claim(newManifestPath, newOwner, newPaths)
+		if err != nil {
+			return err
+		}
+	}
+
+	return manifests.Write()
+}
+
+// releaseOwnership forgets paths in the manifest of the ownership block, on destroy
+func releaseOwnership(worktree *gogit.Worktree, d resourceGetter, paths []string) error {
+	manifestPath, owner := getOwnership(d.Get("ownership"))
+	if owner == "" {
+		return nil
+	}
+
+	manifests := newOwnershipManifests(worktree)
+	err := manifests.Release(manifestPath, owner, paths)
+	if err != nil {
+		return err
+	}
+
+	return manifests.Write()
+}
+
+func ownershipSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Optional: true,
+		MaxItems: 1,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"owner": {
+					Type:         schema.TypeString,
+					Required:     true,
+					ValidateFunc: validation.StringIsNotEmpty,
+				},
+				"manifest_path": {
+					Type:         schema.TypeString,
+					Optional:     true,
+					Default:      defaultManifestPath,
+					ValidateFunc: validateRepositoryPath,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,485 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+)
+
+var patchHunkRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// maxHunkDistance is how many lines away from its stated line a hunk is looked for
+const maxHunkDistance = 100
+
+// patchFile is the change of a single file within a unified diff, an empty path stands for /dev/null.
+// Modes are only set when the patch states them.
+type patchFile struct {
+	oldPath string
+	newPath string
+	oldMode os.FileMode
+	mode    os.FileMode
+	hunks   []patchHunk
+}
+
+// patchHunk is a hunk of a unified diff, lines keep their line break unless missing at the end of the file
+type patchHunk struct {
+	oldStart int
+	newStart int
+	oldLines []string
+	newLines []string
+}
+
+// parsePatch parses unified diff or git format-patch text, anything outside of file changes is ignored
+func parsePatch(text string) ([]*patchFile, error) {
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+
+	var files []*patchFile
+	var header *patchFile
+
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			header = &patchFile{}
+			files = append(files, header)
+
+			// Paths of changes without hunks such as renames and empty files are only found in the header
+			paths := strings.SplitN(strings.TrimPrefix(line, "diff --git "), " b/", 2)
+			if len(paths) == 2 {
+				header.oldPath = strings.TrimPrefix(paths[0], "a/")
+				header.newPath = paths[1]
+			}
+		case header != nil && strings.HasPrefix(line, "new file mode "):
+			header.oldPath = ""
+			mode, err := parsePatchMode(strings.TrimPrefix(line, "new file mode "))
+			if err != nil {
+				return nil, err
+			}
+			header.mode = mode
+		case header != nil && strings.HasPrefix(line, "deleted file mode "):
+			header.newPath = ""
+			mode, err := parsePatchMode(strings.TrimPrefix(line, "deleted file mode "))
+			if err != nil {
+				return nil, err
+			}
+			header.oldMode = mode
+		case header != nil && strings.HasPrefix(line, "old mode "):
+			mode, err := parsePatchMode(strings.TrimPrefix(line, "old mode "))
+			if err != nil {
+				return nil, err
+			}
+			header.oldMode = mode
+		case header != nil && strings.HasPrefix(line, "new mode "):
+			mode, err := parsePatchMode(strings.TrimPrefix(line, "new mode "))
+			if err != nil {
+				return nil, err
+			}
+			header.mode = mode
+		case header != nil && strings.HasPrefix(line, "rename from "):
+			header.oldPath = strings.TrimPrefix(line, "rename from ")
+		case header != nil && strings.HasPrefix(line, "rename to "):
+			header.newPath = strings.TrimPrefix(line, "rename to ")
+		case strings.HasPrefix(line, "GIT binary patch"), strings.HasPrefix(line, "Binary files "):
+			return nil, fmt.Errorf("binary patches are not supported")
+		case strings.HasPrefix(line, "--- ") && i+1 < len(lines) && strings.HasPrefix(lines[i+1], "+++ "):
+			file := header
+			if file == nil || len(file.hunks) > 0 {
+				file = &patchFile{}
+				files = append(files, file)
+			}
+			file.oldPath = parsePatchPath(strings.TrimPrefix(line, "--- "), "a/")
+			file.newPath = parsePatchPath(strings.TrimPrefix(lines[i+1], "+++ "), "b/")
+			header = file
+			i++
+		case strings.HasPrefix(line, "@@ "):
+			if header == nil {
+				return nil, fmt.Errorf("found hunk %q before any file", line)
+			}
+
+			hunk, next, err := parsePatchHunk(lines, i)
+			if err != nil {
+				return nil, err
+			}
+			header.hunks = append(header.hunks, *hunk)
+			i = next - 1
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no file change found")
+	}
+	for _, file := range files {
+		for _, path := range []string{file.oldPath, file.newPath} {
+			if path == "" {
+				continue
+			}
+			if _, errs := validateRepositoryPath(path, "path"); len(errs) > 0 {
+				return nil, fmt.Errorf("invalid path %s: %s", path, errs[0])
+			}
+		}
+	}
+
+	return files, nil
+}
+
+// parsePatchMode returns the permissions of a regular or executable file mode of a git diff header
+func parsePatchMode(text string) (os.FileMode, error) {
+	mode, err := filemode.New(strings.TrimSpace(text))
+	if err != nil {
+		return 0, fmt.Errorf("invalid file mode %s: %s", text, err)
+	}
+
+	switch mode {
+	case filemode.Regular, filemode.Deprecated:
+		return 0644, nil
+	case filemode.Executable:
+		return 0755, nil
+	}
+
+	return 0, fmt.Errorf("file mode %s is not supported, only regular and executable files can be patched", text)
+}
+
+// parsePatchPath returns the path of a ---/+++ line without its timestamp and git prefix
+func parsePatchPath(line string, prefix string) string {
+	path := strings.SplitN(line, "\t", 2)[0]
+	if path == "/dev/null" {
+		return ""
+	}
+
+	return strings.TrimPrefix(path, prefix)
+}
+
+// parsePatchHunk parses the hunk starting at the given line, returning the index of the line following it
+func parsePatchHunk(lines []string, start int) (*patchHunk, int, error) {
+	matches := patchHunkRegexp.FindStringSubmatch(lines[start])
+	if matches == nil {
+		return nil, 0, fmt.Errorf("invalid hunk header %q", lines[start])
+	}
+
+	hunk := &patchHunk{}
+	hunk.oldStart, _ = strconv.Atoi(matches[1])
+	hunk.newStart, _ = strconv.Atoi(matches[3])
+	oldCount, newCount := 1, 1
+	if matches[2] != "" {
+		oldCount, _ = strconv.Atoi(matches[2])
+	}
+	if matches[4] != "" {
+		newCount, _ = strconv.Atoi(matches[4])
+	}
+
+	// The last line read, so that a missing line break can be applied to it
+	var lastOld, lastNew bool
+
+	i := start + 1
+	for ; i < len(lines); i++ {
+		line := lines[i]
+
+		if strings.HasPrefix(line, `\`) {
+			if lastOld {
+				hunk.oldLines[len(hunk.oldLines)-1] = strings.TrimSuffix(hunk.oldLines[len(hunk.oldLines)-1], "\n")
+			}
+			if lastNew {
+				hunk.newLines[len(hunk.newLines)-1] = strings.TrimSuffix(hunk.newLines[len(hunk.newLines)-1], "\n")
+			}
+			continue
+		}
+
+		if len(hunk.oldLines) == oldCount && len(hunk.newLines) == newCount {
+			break
+		}
+
+		// Some tools strip the space of empty context lines
+		if line == "" {
+			line = " "
+		}
+
+		switch line[0] {
+		case ' ':
+			hunk.oldLines = append(hunk.oldLines, line[1:]+"\n")
+			hunk.newLines = append(hunk.newLines, line[1:]+"\n")
+			lastOld, lastNew = true, true
+		case '-':
+			hunk.oldLines = append(hunk.oldLines, line[1:]+"\n")
+			lastOld, lastNew = true, false
+		case '+':
+			hunk.newLines = append(hunk.newLines, line[1:]+"\n")
+			lastOld, lastNew = false, true
+		default:
+			return nil, 0, fmt.Errorf("invalid line %q in hunk %q", line, lines[start])
+		}
+
+		if len(hunk.oldLines) > oldCount || len(hunk.newLines) > newCount {
+			return nil, 0, fmt.Errorf("hunk %q is longer than its header states", lines[start])
+		}
+	}
+
+	if len(hunk.oldLines) != oldCount || len(hunk.newLines) != newCount {
+		return nil, 0, fmt.Errorf("hunk %q is truncated", lines[start])
+	}
+
+	return hunk, i, nil
+}
+
+// reversePatch returns the patch undoing the given one
+func reversePatch(files []*patchFile) []*patchFile {
+	reversed := make([]*patchFile, 0, len(files))
+
+	for i := len(files) - 1; i >= 0; i-- {
+		file := &patchFile{
+			oldPath: files[i].newPath,
+			newPath: files[i].oldPath,
+			oldMode: files[i].mode,
+			mode:    files[i].oldMode,
+		}
+		for _, hunk := range files[i].hunks {
+			file.hunks = append(file.hunks, patchHunk{
+				oldStart: hunk.newStart,
+				newStart: hunk.oldStart,
+				oldLines: hunk.newLines,
+				newLines: hunk.oldLines,
+			})
+		}
+
+		reversed = append(reversed, file)
+	}
+
+	return reversed
+}
+
+// applyHunks applies hunks to content, checking their context. A hunk is looked for
+// up to maxHunkDistance lines around its line when previous changes to the file shifted it.
+func applyHunks(content string, hunks []patchHunk) (string, error) {
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	offset := 0
+	minimum := 0
+	for i, hunk := range hunks {
+		base := hunk.oldStart - 1
+		if len(hunk.oldLines) == 0 {
+			base = hunk.oldStart
+		}
+
+		position := -1
+		expected := base + offset
+		for distance := 0; position < 0 && distance <= maxHunkDistance && (expected-distance >= minimum || expected+distance <= len(lines)-len(hunk.oldLines)); distance++ {
+			for _, candidate := range []int{expected - distance, expected + distance} {
+				if candidate >= minimum && candidate <= len(lines)-len(hunk.oldLines) && matchLines(lines[candidate:], hunk.oldLines) {
+					position = candidate
+					break
+				}
+			}
+		}
+		if position < 0 {
+			return "", fmt.Errorf("hunk #%d does not apply at line %d", i+1, hunk.oldStart)
+		}
+
+		result := append([]string{}, lines[:position]...)
+		result = append(result, hunk.newLines...)
+		lines = append(result, lines[position+len(hunk.oldLines):]...)
+
+		offset = position - base + len(hunk.newLines) - len(hunk.oldLines)
+		minimum = position + len(hunk.newLines)
+	}
+
+	return strings.Join(lines, ""), nil
+}
+
+func matchLines(lines []string, expected []string) bool {
+	for i := range expected {
+		if lines[i] != expected[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// patchedFile is the content and permissions of a file once patched, nil content stands for a deleted file
+type patchedFile struct {
+	content *string
+	mode    os.FileMode
+}
+
+// patchFiles applies the patch to the files without writing them, failing when a hunk is rejected
+// or a file does not have the mode the patch expects
+func patchFiles(filesystem billy.Filesystem, files []*patchFile) (map[string]*patchedFile, error) {
+	patched := map[string]*patchedFile{}
+
+	read := func(path string) (*patchedFile, error) {
+		if file, ok := patched[path]; ok {
+			return file, nil
+		}
+
+		content, mode, err := readFile(filesystem, filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			return &patchedFile{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		switch mode {
+		case filemode.Symlink:
+			return nil, fmt.Errorf("file %s is a symlink, which cannot be patched", path)
+		case filemode.Executable:
+			text := string(content)
+			return &patchedFile{content: &text, mode: 0755}, nil
+		}
+
+		text := string(content)
+		return &patchedFile{content: &text, mode: 0644}, nil
+	}
+
+	for _, file := range files {
+		content := ""
+		mode := os.FileMode(0644)
+
+		if file.oldPath != "" {
+			current, err := read(file.oldPath)
+			if err != nil {
+				return nil, err
+			}
+			if current.content == nil {
+				return nil, fmt.Errorf("file %s does not exist", file.oldPath)
+			}
+			if file.oldMode != 0 && current.mode != file.oldMode {
+				return nil, fmt.Errorf("file %s has mode %o instead of %o", file.oldPath, current.mode, file.oldMode)
+			}
+			content = *current.content
+			mode = current.mode
+		} else if file.newPath != "" {
+			current, err := read(file.newPath)
+			if err != nil {
+				return nil, err
+			}
+			if current.content != nil {
+				return nil, fmt.Errorf("file %s already exists", file.newPath)
+			}
+		}
+		if file.mode != 0 {
+			mode = file.mode
+		}
+
+		content, err := applyHunks(content, file.hunks)
+		if err != nil {
+			if file.newPath != "" {
+				return nil, fmt.Errorf("failed to patch file %s: %s", file.newPath, err)
+			}
+			return nil, fmt.Errorf("failed to patch file %s: %s", file.oldPath, err)
+		}
+
+		if file.oldPath != "" && file.oldPath != file.newPath {
+			patched[file.oldPath] = &patchedFile{}
+		}
+		if file.newPath == "" {
+			if content != "" {
+				return nil, fmt.Errorf("failed to patch file %s: content is left after deleting it", file.oldPath)
+			}
+			continue
+		}
+
+		patched[file.newPath] = &patchedFile{
+			content: &content,
+			mode:    mode,
+		}
+	}
+
+	return patched, nil
+}
+
+// patchApplied returns whether the patch is already applied, that is its reverse applies cleanly
+func patchApplied(filesystem billy.Filesystem, files []*patchFile) bool {
+	_, err := patchFiles(filesystem, reversePatch(files))
+	return err == nil
+}
+
+// writePatch applies the patch to the worktree, unless it is already applied
+func writePatch(worktree *gogit.Worktree, patch string) error {
+	if patch == "" {
+		return nil
+	}
+
+	files, err := parsePatch(patch)
+	if err != nil {
+		return fmt.Errorf("failed to parse patch: %s", err)
+	}
+
+	if patchApplied(worktree.Filesystem, files) {
+		return nil
+	}
+
+	patched, err := patchFiles(worktree.Filesystem, files)
+	if err != nil {
+		return fmt.Errorf("failed to apply patch: %s", err)
+	}
+
+	paths := make([]string, 0, len(patched))
+	for path := range patched {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		file := patched[path]
+
+		if file.content == nil {
+			_, err := worktree.Remove(path)
+			if err != nil && !errors.Is(err, index.ErrEntryNotFound) && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete file %s: %s", path, err)
+			}
+			err = worktree.Filesystem.Remove(path)
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete file %s: %s", path, err)
+			}
+			continue
+		}
+
+		err = writeFile(worktree.Filesystem, path, strings.NewReader(*file.content), file.mode)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readPatch returns the patch when it is still applied to the files, or an empty patch so that it shows up in the plan
+func readPatch(filesystem billy.Filesystem, patch string) string {
+	if patch == "" {
+		return ""
+	}
+
+	files, err := parsePatch(patch)
+	if err != nil || !patchApplied(filesystem, files) {
+		return ""
+	}
+
+	return patch
+}
+
+func validatePatch(value interface{}, key string) ([]string, []error) {
+	patch, ok := value.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", key)}
+	}
+
+	_, err := parsePatch(patch)
+	if err != nil {
+		return nil, []error{fmt.Errorf("expected %s to be a unified diff: %s", key, err)}
+	}
+
+	return nil, nil
+}
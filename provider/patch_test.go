@@ -0,0 +1,319 @@
+package provider
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/memfs"
+)
+
+func TestParsePatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		patch   string
+		oldPath string
+		newPath string
+		oldMode os.FileMode
+		mode    os.FileMode
+		hunks   int
+		err     string
+	}{
+		{
+			name:    "unified diff",
+			patch:   "--- a/file.txt\t2021-01-01 00:00:00\n+++ b/file.txt\n@@ -1 +1 @@\n-a\n+b\n",
+			oldPath: "file.txt",
+			newPath: "file.txt",
+			hunks:   1,
+		},
+		{
+			name:    "mode change",
+			patch:   "diff --git a/run.sh b/run.sh\nold mode 100644\nnew mode 100755\n",
+			oldPath: "run.sh",
+			newPath: "run.sh",
+			oldMode: 0644,
+			mode:    0755,
+		},
+		{
+			name:    "new file",
+			patch:   "diff --git a/new.txt b/new.txt\nnew file mode 100644\n--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+a\n",
+			newPath: "new.txt",
+			mode:    0644,
+			hunks:   1,
+		},
+		{
+			name:    "rename",
+			patch:   "diff --git a/old.txt b/new.txt\nsimilarity index 100%\nrename from old.txt\nrename to new.txt\n",
+			oldPath: "old.txt",
+			newPath: "new.txt",
+		},
+		{
+			name:  "symlink",
+			patch: "diff --git a/link b/link\nnew file mode 120000\n--- /dev/null\n+++ b/link\n@@ -0,0 +1 @@\n+target\n\\ No newline at end of file\n",
+			err:   "only regular and executable files can be patched",
+		},
+		{
+			name:  "binary",
+			patch: "diff --git a/image.png b/image.png\nBinary files a/image.png and b/image.png differ\n",
+			err:   "binary patches are not supported",
+		},
+		{
+			name:  "path outside of the repository",
+			patch: "--- a/../file.txt\n+++ b/../file.txt\n@@ -1 +1 @@\n-a\n+b\n",
+			err:   "invalid path ../file.txt",
+		},
+		{
+			name:  "truncated hunk",
+			patch: "--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,2 @@\n-a\n+b\n",
+			err:   "is truncated",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := parsePatch(test.patch)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if len(files) != 1 {
+				t.Fatalf("expected a single file, got %d", len(files))
+			}
+
+			file := files[0]
+			if file.oldPath != test.oldPath || file.newPath != test.newPath {
+				t.Errorf("expected paths %q and %q, got %q and %q", test.oldPath, test.newPath, file.oldPath, file.newPath)
+			}
+			if file.oldMode != test.oldMode || file.mode != test.mode {
+				t.Errorf("expected modes %o and %o, got %o and %o", test.oldMode, test.mode, file.oldMode, file.mode)
+			}
+			if len(file.hunks) != test.hunks {
+				t.Errorf("expected %d hunks, got %d", test.hunks, len(file.hunks))
+			}
+		})
+	}
+}
+
+func TestApplyHunks(t *testing.T) {
+	hunk := patchHunk{
+		oldStart: 1,
+		newStart: 1,
+		oldLines: []string{"a\n", "b\n"},
+		newLines: []string{"a\n", "c\n"},
+	}
+
+	tests := []struct {
+		name     string
+		content  string
+		hunks    []patchHunk
+		expected string
+		err      string
+	}{
+		{
+			name:     "at its line",
+			content:  "a\nb\nz\n",
+			hunks:    []patchHunk{hunk},
+			expected: "a\nc\nz\n",
+		},
+		{
+			name:     "shifted",
+			content:  "x\nx\na\nb\n",
+			hunks:    []patchHunk{hunk},
+			expected: "x\nx\na\nc\n",
+		},
+		{
+			name:     "shifted by the maximum distance",
+			content:  strings.Repeat("x\n", maxHunkDistance) + "a\nb\n",
+			hunks:    []patchHunk{hunk},
+			expected: strings.Repeat("x\n", maxHunkDistance) + "a\nc\n",
+		},
+		{
+			name:    "shifted beyond the maximum distance",
+			content: strings.Repeat("x\n", maxHunkDistance+1) + "a\nb\n",
+			hunks:   []patchHunk{hunk},
+			err:     "hunk #1 does not apply at line 1",
+		},
+		{
+			name:    "context mismatch",
+			content: "a\nd\n",
+			hunks:   []patchHunk{hunk},
+			err:     "hunk #1 does not apply at line 1",
+		},
+		{
+			name:    "hunks offset by the previous ones",
+			content: "a\nb\nm\nn\n",
+			hunks: []patchHunk{
+				{oldStart: 1, newStart: 1, oldLines: []string{"a\n"}, newLines: []string{"a\n", "a2\n"}},
+				{oldStart: 4, newStart: 5, oldLines: []string{"n\n"}, newLines: []string{"o\n"}},
+			},
+			expected: "a\na2\nb\nm\no\n",
+		},
+		{
+			name:    "hunks out of order",
+			content: "a\nb\n",
+			hunks: []patchHunk{
+				{oldStart: 2, newStart: 2, oldLines: []string{"b\n"}, newLines: []string{"c\n"}},
+				{oldStart: 1, newStart: 1, oldLines: []string{"a\n"}, newLines: []string{"d\n"}},
+			},
+			err: "hunk #2 does not apply at line 1",
+		},
+		{
+			name:     "missing line break at the end",
+			content:  "a\nb",
+			hunks:    []patchHunk{{oldStart: 2, newStart: 2, oldLines: []string{"b"}, newLines: []string{"b\n"}}},
+			expected: "a\nb\n",
+		},
+		{
+			name:     "empty file",
+			content:  "",
+			hunks:    []patchHunk{{oldStart: 0, newStart: 1, newLines: []string{"a\n"}}},
+			expected: "a\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			content, err := applyHunks(test.content, test.hunks)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if content != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, content)
+			}
+		})
+	}
+}
+
+func TestPatchApplied(t *testing.T) {
+	patch := "--- a/file.txt\n+++ b/file.txt\n@@ -1,2 +1,2 @@\n a\n-b\n+c\n" +
+		"--- /dev/null\n+++ b/new.txt\n@@ -0,0 +1 @@\n+n\n"
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		applied bool
+	}{
+		{
+			name:    "not applied",
+			files:   map[string]string{"file.txt": "a\nb\n"},
+			applied: false,
+		},
+		{
+			name:    "applied",
+			files:   map[string]string{"file.txt": "a\nc\n", "new.txt": "n\n"},
+			applied: true,
+		},
+		{
+			name:    "applied then shifted",
+			files:   map[string]string{"file.txt": "x\na\nc\n", "new.txt": "n\n"},
+			applied: true,
+		},
+		{
+			name:    "partly applied",
+			files:   map[string]string{"file.txt": "a\nc\n"},
+			applied: false,
+		},
+		{
+			name:    "changed since",
+			files:   map[string]string{"file.txt": "a\nd\n", "new.txt": "n\n"},
+			applied: false,
+		},
+	}
+
+	files, err := parsePatch(patch)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			filesystem := memfs.New()
+			for path, content := range test.files {
+				if err := writeFile(filesystem, path, strings.NewReader(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if applied := patchApplied(filesystem, files); applied != test.applied {
+				t.Errorf("expected applied to be %t, got %t", test.applied, applied)
+			}
+		})
+	}
+}
+
+func TestPatchFiles(t *testing.T) {
+	filesystem := memfs.New()
+	if err := writeFile(filesystem, "run.sh", strings.NewReader("echo a\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := filesystem.Symlink("run.sh", "link"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		patch string
+		mode  os.FileMode
+		err   string
+	}{
+		{
+			name:  "keeps the mode",
+			patch: "--- a/run.sh\n+++ b/run.sh\n@@ -1 +1 @@\n-echo a\n+echo b\n",
+			mode:  0755,
+		},
+		{
+			name:  "mode change",
+			patch: "diff --git a/run.sh b/run.sh\nold mode 100755\nnew mode 100644\n",
+			mode:  0644,
+		},
+		{
+			name:  "unexpected mode",
+			patch: "diff --git a/run.sh b/run.sh\nold mode 100644\nnew mode 100755\n",
+			err:   "file run.sh has mode 755 instead of 644",
+		},
+		{
+			name:  "symlink",
+			patch: "--- a/link\n+++ b/link\n@@ -1 +1 @@\n-run.sh\n+other.sh\n",
+			err:   "file link is a symlink",
+		},
+		{
+			name:  "existing file",
+			patch: "--- /dev/null\n+++ b/run.sh\n@@ -0,0 +1 @@\n+echo a\n",
+			err:   "file run.sh already exists",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			files, err := parsePatch(test.patch)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+
+			patched, err := patchFiles(filesystem, files)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if file := patched["run.sh"]; file == nil || file.mode != test.mode {
+				t.Errorf("expected run.sh to have mode %o, got %+v", test.mode, file)
+			}
+		})
+	}
+}
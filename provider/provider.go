@@ -8,7 +8,9 @@ func Provider() *schema.Provider {
 	return &schema.Provider{
 		Schema: map[string]*schema.Schema{},
 		ResourcesMap: map[string]*schema.Resource{
-			"git_commit": resourceCommit(),
+			"git_commit":          resourceCommit(),
+			"git_repository_file": resourceRepositoryFile(),
+			"git_sync":            resourceSync(),
 		},
 		DataSourcesMap: map[string]*schema.Resource{
 			"git_repository": dataRepository(),
@@ -19,8 +21,17 @@ func Provider() *schema.Provider {
 }
 
 func providerConfigure(data *schema.ResourceData) (interface{}, error) {
-	config := &providerConfig{}
+	locks := newBranchLocks()
+	config := &providerConfig{
+		locks:   locks,
+		batcher: newCommitBatcher(locks),
+		sources: newSourceCache(),
+	}
 	return config, nil
 }
 
-type providerConfig struct{}
+type providerConfig struct {
+	locks   *branchLocks
+	batcher *commitBatcher
+	sources *sourceCache
+}
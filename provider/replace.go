@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+// writeReplacements applies the regex substitutions to their file, returning their match count keyed by path#pattern.
+// A pattern which already got replaced by a previous apply is expected to match no more, and keeps its previous count.
+func writeReplacements(filesystem billy.Filesystem, d resourceGetter) (map[string]interface{}, diag.Diagnostics, error) {
+	oldReplacements, newReplacements := d.GetChange("replace")
+	oldCounts, _ := d.GetChange("replace_match_count")
+
+	applied := map[string]bool{}
+	for _, replacement := range oldReplacements.([]interface{}) {
+		applied[replacementKey(replacement.(map[string]interface{}))] = true
+	}
+
+	var diags diag.Diagnostics
+	counts := map[string]interface{}{}
+
+	for _, replacement := range newReplacements.([]interface{}) {
+		path := replacement.(map[string]interface{})["path"].(string)
+		pattern := replacement.(map[string]interface{})["pattern"].(string)
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to compile pattern %s: %s", pattern, err)
+		}
+
+		count := 0
+		_, err = filesystem.Lstat(filesystem.Join(path))
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, nil, fmt.Errorf("failed to stat file %s: %s", path, err)
+		} else if err == nil {
+			err = editFile(filesystem, filesystem.Join(path), func(file string) (string, error) {
+				count = len(re.FindAllStringIndex(file, -1))
+				replaced := re.ReplaceAllString(file, replacement.(map[string]interface{})["replacement"].(string))
+
+				// Every apply would replace the result again
+				if re.ReplaceAllString(replaced, replacement.(map[string]interface{})["replacement"].(string)) != replaced {
+					return "", fmt.Errorf("pattern %s still matches its replacement in file %s, it would be replaced again by every apply: exclude the replacement from the pattern", pattern, path)
+				}
+
+				return replaced, nil
+			})
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
+		var behavior string
+		switch {
+		case count == 0 && applied[replacementKey(replacement.(map[string]interface{}))]:
+			if oldCount, ok := oldCounts.(map[string]interface{})[matchCountKey(replacement.(map[string]interface{}))]; ok {
+				count = oldCount.(int)
+			}
+		case count == 0:
+			behavior = replacement.(map[string]interface{})["on_no_match"].(string)
+		case count > 1:
+			behavior = replacement.(map[string]interface{})["on_multiple_matches"].(string)
+		}
+
+		switch behavior {
+		case "error":
+			return nil, nil, fmt.Errorf("pattern %s matched %d times in file %s", pattern, count, path)
+		case "warn":
+			diags = append(diags, diag.Diagnostic{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Pattern matched %d times", count),
+				Detail:   fmt.Sprintf("The pattern %s matched %d times in file %s", pattern, count, path),
+			})
+		}
+
+		counts[matchCountKey(replacement.(map[string]interface{}))] = count
+	}
+
+	return counts, diags, nil
+}
+
+// readReplacements returns the items whose substitution is still in effect, items which would change their file again are dropped
+func readReplacements(filesystem billy.Filesystem, replacements []interface{}) ([]interface{}, error) {
+	remoteReplacements := make([]interface{}, 0, len(replacements))
+
+	for _, replacement := range replacements {
+		path := replacement.(map[string]interface{})["path"].(string)
+		pattern := replacement.(map[string]interface{})["pattern"].(string)
+
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile pattern %s: %s", pattern, err)
+		}
+
+		file, _, err := readFile(filesystem, filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if re.ReplaceAllString(string(file), replacement.(map[string]interface{})["replacement"].(string)) != string(file) {
+			continue
+		}
+
+		remoteReplacements = append(remoteReplacements, replacement)
+	}
+
+	return remoteReplacements, nil
+}
+
+// matchCountKey identifies the match count of a substitution by its file and pattern
+func matchCountKey(replacement map[string]interface{}) string {
+	return fmt.Sprintf("%s#%s", replacement["path"], replacement["pattern"])
+}
+
+// replacementKey identifies a substitution by its file, pattern and replacement
+func replacementKey(replacement map[string]interface{}) string {
+	return fmt.Sprintf("%s#%s#%s", replacement["path"], replacement["pattern"], replacement["replacement"])
+}
@@ -2,20 +2,29 @@ package provider
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
-	"time"
+	"path"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
 
-	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-billy/v5"
 	gogit "github.com/go-git/go-git/v5"
-	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
@@ -27,6 +36,27 @@ func resourceCommit() *schema.Resource {
 		UpdateContext: resourceCommitUpdate,
 		DeleteContext: resourceCommitDelete,
 
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceCommitImport,
+		},
+
+		SchemaVersion: 1,
+		StateUpgraders: []schema.StateUpgrader{
+			{
+				Version: 0,
+				Type:    resourceCommitV0().CoreConfigSchema().ImpliedType(),
+				Upgrade: resourceCommitStateUpgradeV0,
+			},
+		},
+
+		CustomizeDiff: customdiff.All(
+			resourceCommitDiffContents,
+			resourceCommitDiffPaths,
+			resourceCommitDiffSources,
+			resourceCommitDiffSourceBlobs,
+			resourceCommitDiffPlannedChanges,
+		),
+
 		Schema: map[string]*schema.Schema{
 			"url": {
 				Type:         schema.TypeString,
@@ -53,19 +83,249 @@ func resourceCommit() *schema.Resource {
 				Optional: true,
 			},
 			"add": {
-				Type:     schema.TypeList,
-				Required: true,
-				MinItems: 1,
+				Type:         schema.TypeSet,
+				Optional:     true,
+				MinItems:     1,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"path": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateRepositoryPath,
+						},
+						"content": {
 							Type:     schema.TypeString,
-							Required: true,
+							Optional: true,
+						},
+						"content_base64": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringIsBase64,
+						},
+						"source": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"mode": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringInSlice([]string{"100644", "100755", "120000"}, false),
+						},
+						"write_mode": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringInSlice([]string{"create_only", "overwrite", "append"}, false),
+						},
+						"source_ref": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"source_url": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.IsURLWithScheme([]string{"http", "https", "ssh"}),
+						},
+						"source_path": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateRepositoryPath,
+						},
+					},
+				},
+			},
+			"add_block": {
+				Type:         schema.TypeList,
+				Optional:     true,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateRepositoryPath,
 						},
 						"content": {
 							Type:     schema.TypeString,
 							Required: true,
 						},
+						"marker_begin": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      defaultMarkerBegin,
+							ValidateFunc: validation.StringIsNotEmpty,
+						},
+						"marker_end": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      defaultMarkerEnd,
+							ValidateFunc: validation.StringIsNotEmpty,
+						},
+					},
+				},
+			},
+			"set_value": {
+				Type:         schema.TypeList,
+				Optional:     true,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateRepositoryPath,
+						},
+						"format": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringInSlice([]string{"json", "yaml", "toml"}, false),
+						},
+						"key": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringMatch(regexp.MustCompile(`^[^.]+(\.[^.]+)*$`), "must be a dot-separated key path"),
+						},
+						"value": {
+							Type:             schema.TypeString,
+							Required:         true,
+							ValidateFunc:     validation.StringIsJSON,
+							DiffSuppressFunc: suppressEquivalentJSON,
+						},
+					},
+				},
+			},
+			"replace": {
+				Type:         schema.TypeList,
+				Optional:     true,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateRepositoryPath,
+						},
+						"pattern": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringIsValidRegExp,
+						},
+						"replacement": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"on_no_match": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "error",
+							ValidateFunc: validation.StringInSlice([]string{"error", "warn", "ignore"}, false),
+						},
+						"on_multiple_matches": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "warn",
+							ValidateFunc: validation.StringInSlice([]string{"error", "warn", "ignore"}, false),
+						},
+					},
+				},
+			},
+			"patch": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
+				ValidateFunc: validatePatch,
+			},
+			"submodule": {
+				Type:         schema.TypeList,
+				Optional:     true,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateRepositoryPath,
+						},
+						"sha": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringMatch(regexp.MustCompile(`^[0-9a-f]{40}$`), "must be a full commit SHA"),
+						},
+						"url": {
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
+			"add_directory": {
+				Type:         schema.TypeList,
+				Optional:     true,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"source": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"destination": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "",
+							ValidateFunc: validation.Any(validation.StringIsEmpty, validateRepositoryPath),
+						},
+						"include": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"exclude": {
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"exclusive": {
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+					},
+				},
+			},
+			"remove": {
+				Type:         schema.TypeList,
+				Optional:     true,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateRepositoryPath,
+						},
+					},
+				},
+			},
+			"move": {
+				Type:         schema.TypeList,
+				Optional:     true,
+				AtLeastOneOf: []string{"add", "add_directory", "add_block", "set_value", "replace", "patch", "submodule", "remove", "move"},
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"from": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateRepositoryPath,
+						},
+						"to": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateRepositoryPath,
+						},
 					},
 				},
 			},
@@ -74,7 +334,24 @@ func resourceCommit() *schema.Resource {
 				Optional: true,
 				Default:  false,
 			},
-			"auth": authSchema(),
+			"orphan": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"on_destroy": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"keep", "delete", "restore"}, false),
+			},
+			"expected_parent_sha": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringMatch(regexp.MustCompile("^[0-9a-f]{40}$"), "must be a full commit SHA"),
+			},
+			"ownership": ownershipSchema(),
+			"lock":      lockSchema(),
+			"auth":      authSchema(),
 
 			"sha": {
 				Type:     schema.TypeString,
@@ -84,234 +361,340 @@ func resourceCommit() *schema.Resource {
 				Type:     schema.TypeBool,
 				Computed: true,
 			},
+			"parent_sha": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"changes":         changesSchema(),
+			"planned_changes": changesSchema(),
+			"source_sha256": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"source_blob_sha": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"original_blobs": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"replace_match_count": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeInt,
+				},
+			},
 		},
 	}
 }
 
-func resourceCommitCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	url := d.Get("url").(string)
-	branch := d.Get("branch").(string)
-	message := d.Get("message").(string)
-	items := d.Get("add").([]interface{})
-
-	// Clone repository
-	auth, err := getAuth(d)
-	if err != nil {
-		return diag.Errorf("failed to prepare authentication: %s", err)
+// resourceCommitDiffContents requires a single content for each add item, an item without any would empty the file.
+// The raw config is used as an unset content and an empty one both read as "".
+func resourceCommitDiffContents(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	config := d.GetRawConfig()
+	if !config.IsKnown() || config.IsNull() {
+		return nil
+	}
+	items := config.GetAttr("add")
+	if !items.IsKnown() || items.IsNull() {
+		return nil
 	}
 
-	repo, err := gogit.CloneContext(ctx, memory.NewStorage(), memfs.New(), &gogit.CloneOptions{
-		URL:  url,
-		Auth: auth,
-	})
-	if err != nil {
-		return diag.Errorf("failed to clone repository: %s", err)
+	for it := items.ElementIterator(); it.Next(); {
+		_, item := it.Element()
+		if !item.IsKnown() || item.IsNull() {
+			continue
+		}
+
+		// source_ref and source_url both select a remote source
+		var contents []string
+		for _, keys := range [][]string{{"content"}, {"content_base64"}, {"source"}, {"source_ref", "source_url"}} {
+			for _, key := range keys {
+				if !item.GetAttr(key).IsNull() {
+					contents = append(contents, key)
+					break
+				}
+			}
+		}
+		if len(contents) == 1 {
+			continue
+		}
+
+		path := "with an unknown path"
+		if value := item.GetAttr("path"); value.IsKnown() && !value.IsNull() {
+			path = value.AsString()
+		}
+		if len(contents) == 0 {
+			return fmt.Errorf("file %s has no content, set one of content, content_base64, source, source_ref or source_url", path)
+		}
+		return fmt.Errorf("file %s sets both %s, only one content can be set", path, strings.Join(contents, " and "))
 	}
 
-	// Get the current worktree
-	worktree, err := repo.Worktree()
-	if err != nil {
-		return diag.Errorf("failed to get worktree: %s", err)
+	return nil
+}
+
+func resourceCommitDiffPaths(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	// Files are keyed by path, so a path can only be added once
+	paths := map[string]bool{}
+	for _, item := range d.Get("add").(*schema.Set).List() {
+		path := path.Clean(item.(map[string]interface{})["path"].(string))
+		if paths[path] {
+			return fmt.Errorf("path %s is added more than once", path)
+		}
+		paths[path] = true
 	}
 
-	// Resolve then checkout the specified branch
-	sha, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName("origin", branch)))
-	if err != nil && errors.Is(err, plumbing.ErrReferenceNotFound) {
-		sha, err = repo.ResolveRevision(plumbing.Revision(plumbing.NewBranchReferenceName(branch)))
+	// Blocks sharing a path and markers would overwrite each other on every apply
+	blocks := map[string]bool{}
+	for _, item := range d.Get("add_block").([]interface{}) {
+		block := item.(map[string]interface{})
+		if block["path"].(string) == "" {
+			continue
+		}
+
+		blockPath := path.Clean(block["path"].(string))
+		key := fmt.Sprintf("%s\n%s\n%s", blockPath, block["marker_begin"].(string), block["marker_end"].(string))
+		if blocks[key] {
+			return fmt.Errorf("block %s of file %s is added more than once, set distinct marker_begin and marker_end", block["marker_begin"].(string), blockPath)
+		}
+		blocks[key] = true
 	}
-	if err != nil {
-		return diag.Errorf("failed to resolve branch %s: %s", branch, err)
+
+	// Values set twice for the same key, or for a key and a key within it, would replace each other on every apply
+	keys := map[string][]string{}
+	for _, item := range d.Get("set_value").([]interface{}) {
+		value := item.(map[string]interface{})
+		if value["path"].(string) == "" || value["key"].(string) == "" {
+			continue
+		}
+
+		valuePath := path.Clean(value["path"].(string))
+		key := value["key"].(string)
+		for _, other := range keys[valuePath] {
+			switch {
+			case key == other:
+				return fmt.Errorf("key %s of file %s is set more than once", key, valuePath)
+			case strings.HasPrefix(key, other+"."), strings.HasPrefix(other, key+"."):
+				return fmt.Errorf("keys %s and %s of file %s are both set while one is within the other", other, key, valuePath)
+			}
+		}
+		keys[valuePath] = append(keys[valuePath], key)
 	}
 
-	err = worktree.Checkout(&gogit.CheckoutOptions{
-		Hash:  *sha,
-		Force: true,
-	})
-	if err != nil {
-		return diag.Errorf("failed to checkout hash %s: %s", sha.String(), err)
+	return nil
+}
+
+func resourceCommitDiffSources(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	items := d.Get("add").(*schema.Set).List()
+	directories := d.Get("add_directory").([]interface{})
+
+	if !isConfigKnown(d, "add") || !isConfigKnown(d, "add_directory") {
+		return d.SetNewComputed("source_sha256")
 	}
 
-	// Write files
+	// Hash local source files so that their content is diffed without being stored in state
+	sourceHashes := map[string]interface{}{}
 	for _, item := range items {
 		path := item.(map[string]interface{})["path"].(string)
-		content := item.(map[string]interface{})["content"].(string)
+		source := item.(map[string]interface{})["source"].(string)
 
-		path = worktree.Filesystem.Join(path)
+		if source == "" {
+			continue
+		}
 
-		// Create, write then close file
-		file, err := worktree.Filesystem.Create(path)
-		if err != nil {
-			return diag.Errorf("failed to create file %s: %s", path, err)
+		hash, err := hashFile(source)
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			// The source may be generated during apply
+			return d.SetNewComputed("source_sha256")
+		} else if err != nil {
+			return fmt.Errorf("failed to hash source %s: %s", source, err)
 		}
+		sourceHashes[path] = hash
+	}
 
-		_, err = io.WriteString(file, content)
-		if err != nil {
-			return diag.Errorf("failed to write to file %s: %s", path, err)
+	for _, directory := range directories {
+		files, err := listDirectoryFiles(directory.(map[string]interface{}))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			// The directory may be generated during apply
+			return d.SetNewComputed("source_sha256")
+		} else if err != nil {
+			return err
 		}
 
-		err = file.Close()
-		if err != nil {
-			return diag.Errorf("failed to close file %s: %s", path, err)
+		for _, file := range files {
+			hash, err := hashFile(file.source)
+			if err != nil {
+				return fmt.Errorf("failed to hash source %s: %s", file.source, err)
+			}
+			sourceHashes[file.path] = hash
 		}
 	}
 
-	// Check if worktree is clean
-	status, err := worktree.Status()
-	if err != nil {
-		return diag.Errorf("failed to compute worktree status: %s", err)
+	if d.Id() == "" || !reflect.DeepEqual(d.Get("source_sha256").(map[string]interface{}), sourceHashes) {
+		return d.SetNew("source_sha256", sourceHashes)
 	}
-	if status.IsClean() {
-		sha, err := repo.ResolveRevision(plumbing.Revision(plumbing.HEAD))
-		if err != nil {
-			return diag.Errorf("failed to get existing commit: %s", err)
-		}
-
-		d.SetId(sha.String())
-		d.Set("sha", sha.String())
-		d.Set("new", false)
 
-		return nil
-	}
+	return nil
+}
 
-	// Stage worktree
-	err = worktree.AddWithOptions(&gogit.AddOptions{
-		All: true,
-	})
-	if err != nil {
-		return diag.Errorf("failed to stage worktree: %s", err)
+func resourceCommitDiffSourceBlobs(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	if !isConfigKnown(d, "add") {
+		return d.SetNewComputed("source_blob_sha")
 	}
 
-	// Commit
-	commitSha, err := worktree.Commit(message, &gogit.CommitOptions{
-		Author: &object.Signature{
-			Name:  os.Getenv("GIT_AUTHOR_NAME"),
-			Email: os.Getenv("GIT_AUTHOR_EMAIL"),
-			When:  time.Now(),
-		},
-	})
+	auth, err := getAuth(d)
 	if err != nil {
-		return diag.Errorf("failed to commit: %s", err)
+		return fmt.Errorf("failed to prepare authentication: %s", err)
 	}
 
-	// Update branch
-	branchRef := plumbing.NewBranchReferenceName(branch)
-	hashRef := plumbing.NewHashReference(branchRef, commitSha)
-	err = repo.Storer.SetReference(hashRef)
+	// Fetch files copied from other refs or repositories so that changes of the source are diffed
+	_, blobShas, err := resolveItemSources(ctx, meta.(*providerConfig).sources, d.Get("add").(*schema.Set).List(), d.Get("url").(string), auth)
 	if err != nil {
-		return diag.Errorf("failed to set branch ref: %s", err)
+		return err
 	}
 
-	// Push
-	err = repo.PushContext(ctx, &gogit.PushOptions{
-		RefSpecs: []config.RefSpec{
-			config.RefSpec(fmt.Sprintf("%s:%s", branchRef, branchRef)),
-		},
-		Auth: auth,
-	})
-	if err != nil {
-		return diag.Errorf("failed to push: %s", err)
+	if d.Id() == "" || !reflect.DeepEqual(d.Get("source_blob_sha").(map[string]interface{}), blobShas) {
+		return d.SetNew("source_blob_sha", blobShas)
 	}
 
-	d.SetId(commitSha.String())
-	d.Set("sha", commitSha.String())
-	d.Set("new", true)
-
 	return nil
 }
 
-func resourceCommitRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+func resourceCommitDiffPlannedChanges(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
 	url := d.Get("url").(string)
 	branch := d.Get("branch").(string)
-	items := d.Get("add").([]interface{})
+
+	// Changes can only be previewed once the whole configuration is known
+	if !d.GetRawConfig().IsWhollyKnown() || !d.NewValueKnown("source_sha256") || !d.NewValueKnown("source_blob_sha") {
+		for _, key := range []string{"sha", "new", "parent_sha", "changes", "planned_changes", "original_blobs", "replace_match_count"} {
+			err := d.SetNewComputed(key)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
 
 	// Clone repository
 	auth, err := getAuth(d)
 	if err != nil {
-		return diag.Errorf("failed to prepare authentication: %s", err)
+		return fmt.Errorf("failed to prepare authentication: %s", err)
 	}
 
-	repo, err := gogit.CloneContext(ctx, memory.NewStorage(), memfs.New(), &gogit.CloneOptions{
-		URL:  url,
-		Auth: auth,
-	})
+	repo, worktree, sha, err := openBranch(ctx, url, branch, auth, d.Get("orphan").(bool))
 	if err != nil {
-		return diag.Errorf("failed to clone repository: %s", err)
+		return err
 	}
 
-	// Get the current worktree
-	worktree, err := repo.Worktree()
+	// Apply changes to the worktree then commit them without pushing
+	err = pruneFiles(worktree, d)
 	if err != nil {
-		return diag.Errorf("failed to get worktree: %s", err)
+		return err
 	}
 
-	// Resolve then checkout the specified branch
-	sha, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName("origin", branch)))
-	if err != nil && errors.Is(err, plumbing.ErrReferenceNotFound) {
-		sha, err = repo.ResolveRevision(plumbing.Revision(plumbing.NewBranchReferenceName(branch)))
-	}
+	written, err := writeChanges(ctx, repo, worktree, d, auth, meta.(*providerConfig).sources)
 	if err != nil {
-		return diag.Errorf("failed to resolve branch %s: %s", branch, err)
+		return err
 	}
+	sourceHashes := written.sourceHashes
 
-	err = worktree.Checkout(&gogit.CheckoutOptions{
-		Hash:  *sha,
-		Force: true,
-	})
+	blobs, err := originalBlobs(repo, *sha, d, sourceHashes)
 	if err != nil {
-		return diag.Errorf("failed to checkout hash %s: %s", sha.String(), err)
+		return err
+	}
+	if !reflect.DeepEqual(blobs, d.Get("original_blobs").(map[string]interface{})) {
+		err = d.SetNew("original_blobs", blobs)
+		if err != nil {
+			return err
+		}
+	}
+	if !reflect.DeepEqual(written.matchCounts, d.Get("replace_match_count").(map[string]interface{})) {
+		err = d.SetNew("replace_match_count", written.matchCounts)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Write files
-	for _, item := range items {
-		path := item.(map[string]interface{})["path"].(string)
-		content := item.(map[string]interface{})["content"].(string)
+	commitSha, err := commitWorktree(worktree, "Preview")
+	if err != nil {
+		return err
+	}
 
-		path = worktree.Filesystem.Join(path)
+	if commitSha.IsZero() && sha.IsZero() {
+		return fmt.Errorf("branch %s does not exist and there is nothing to commit to create it", branch)
+	}
 
-		// Create, write then close file
-		file, err := worktree.Filesystem.Create(path)
-		if err != nil {
-			return diag.Errorf("failed to create file %s: %s", path, err)
+	if commitSha.IsZero() {
+		// Keep the state untouched when there is nothing to apply
+		if d.Id() != "" && len(d.GetChangedKeysPrefix("")) == 0 {
+			return nil
 		}
 
-		_, err = io.WriteString(file, content)
-		if err != nil {
-			return diag.Errorf("failed to write to file %s: %s", path, err)
+		// The apply will not commit, so the branch tip is already known
+		values := map[string]interface{}{
+			"sha":             sha.String(),
+			"new":             false,
+			"parent_sha":      sha.String(),
+			"changes":         []map[string]interface{}{},
+			"planned_changes": []map[string]interface{}{},
 		}
-
-		err = file.Close()
-		if err != nil {
-			return diag.Errorf("failed to close file %s: %s", path, err)
+		for key, value := range values {
+			err = d.SetNew(key, value)
+			if err != nil {
+				return err
+			}
 		}
+
+		return nil
+	}
+
+	if expected := d.Get("expected_parent_sha").(string); expected != "" && expected != sha.String() {
+		return fmt.Errorf("branch %s is at %s but expected_parent_sha is %s", branch, sha.String(), expected)
 	}
 
-	// Check if worktree is clean
-	status, err := worktree.Status()
+	plannedChanges, err := listChanges(repo, commitSha)
 	if err != nil {
-		return diag.Errorf("failed to compute worktree status: %s", err)
+		return err
 	}
-	if !status.IsClean() {
-		d.SetId("")
-		return nil
+
+	// Capture the branch tip so that the apply does not commit on top of unseen changes
+	err = d.SetNew("parent_sha", tipString(*sha))
+	if err != nil {
+		return err
 	}
 
-	d.SetId(sha.String())
-	d.Set("sha", sha.String())
-	d.Set("new", false)
+	err = d.SetNew("planned_changes", plannedChanges)
+	if err != nil {
+		return err
+	}
+	err = d.SetNew("new", true)
+	if err != nil {
+		return err
+	}
+	err = d.SetNewComputed("sha")
+	if err != nil {
+		return err
+	}
 
-	return nil
+	return d.SetNewComputed("changes")
 }
 
-func resourceCommitUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+func resourceCommitCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) (diags diag.Diagnostics) {
 	url := d.Get("url").(string)
 	branch := d.Get("branch").(string)
 	message := d.Get("message").(string)
-	items := d.Get("add").([]interface{})
-	prune := d.Get("prune").(bool)
-
-	if updateMessage, ok := d.GetOk("update_message"); ok {
-		message = updateMessage.(string)
-	}
 
 	// Clone repository
 	auth, err := getAuth(d)
@@ -319,146 +702,235 @@ func resourceCommitUpdate(ctx context.Context, d *schema.ResourceData, meta inte
 		return diag.Errorf("failed to prepare authentication: %s", err)
 	}
 
-	repo, err := gogit.CloneContext(ctx, memory.NewStorage(), memfs.New(), &gogit.CloneOptions{
-		URL:  url,
-		Auth: auth,
-	})
-	if err != nil {
-		return diag.Errorf("failed to clone repository: %s", err)
+	// Wait for other operations on the branch, then start from the tip they leave
+	locks := meta.(*providerConfig).locks
+	unlock, diags := lockBranch(ctx, d, locks, url, branch, auth)
+	if diags.HasError() {
+		return diags
 	}
+	defer func() {
+		diags = append(diags, unlock(ctx)...)
+	}()
 
-	// Get the current worktree
-	worktree, err := repo.Worktree()
+	repo, worktree, sha, err := openBranch(ctx, url, branch, auth, d.Get("orphan").(bool))
 	if err != nil {
-		return diag.Errorf("failed to get worktree: %s", err)
+		return diag.FromErr(err)
 	}
 
-	// Resolve then checkout the specified branch
-	sha, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName("origin", branch)))
-	if err != nil && errors.Is(err, plumbing.ErrReferenceNotFound) {
-		sha, err = repo.ResolveRevision(plumbing.Revision(plumbing.NewBranchReferenceName(branch)))
+	if diags := checkParent(d, locks, url, branch, *sha); diags.HasError() {
+		return diags
 	}
+
+	// Write files
+	written, err := writeChanges(ctx, repo, worktree, d, auth, meta.(*providerConfig).sources)
 	if err != nil {
-		return diag.Errorf("failed to resolve branch %s: %s", branch, err)
+		return diag.FromErr(err)
 	}
+	sourceHashes := written.sourceHashes
+	diags = append(diags, written.warnings...)
 
-	err = worktree.Checkout(&gogit.CheckoutOptions{
-		Hash:  *sha,
-		Force: true,
-	})
+	// Commit then push
+	commitSha, err := commitAndPush(ctx, repo, worktree, branch, message, auth)
 	if err != nil {
-		return diag.Errorf("failed to checkout hash %s: %s", sha.String(), err)
+		return diag.FromErr(err)
+	}
+	if !commitSha.IsZero() {
+		locks.RecordPush(url, branch, commitSha, *sha)
 	}
 
-	// Prune files
-	if prune && d.HasChange("add") {
-		oldItems, _ := d.GetChange("add")
+	// Remember files as they were before being written, to restore them on destroy
+	blobs, err := originalBlobs(repo, *sha, d, sourceHashes)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("original_blobs", blobs)
+	d.Set("replace_match_count", written.matchCounts)
+	d.Set("source_blob_sha", written.blobShas)
 
-		for _, item := range oldItems.([]interface{}) {
-			path := item.(map[string]interface{})["path"].(string)
-			path = worktree.Filesystem.Join(path)
+	return append(diags, setCommit(d, repo, *sha, commitSha, sourceHashes)...)
+}
 
-			// Delete old files
-			_, err = worktree.Remove(path)
-			if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
-				return diag.Errorf("failed to delete file %s: %s", path, err)
-			}
+func resourceCommitRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+	items := d.Get("add").(*schema.Set).List()
+	sourceHashes := d.Get("source_sha256").(map[string]interface{})
+
+	// Clone repository
+	auth, err := getReadAuth(d)
+	if err != nil {
+		return diag.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	repo, worktree, sha, err := cloneBranch(ctx, url, branch, auth)
+	if err != nil && isBranchNotFound(err) {
+		// The commit is gone along with the branch, it will be created again
+		d.SetId("")
+		return diag.Diagnostics{
+			{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Branch %s not found", branch),
+				Detail:   fmt.Sprintf("The branch %s of %s no longer exists, the commit is removed from the state: %s", branch, url, err),
+			},
 		}
+	} else if err != nil {
+		return diag.FromErr(err)
 	}
 
-	// Write files
+	sourceItems := map[string]map[string]interface{}{}
 	for _, item := range items {
-		path := item.(map[string]interface{})["path"].(string)
-		content := item.(map[string]interface{})["content"].(string)
+		if item.(map[string]interface{})["source"].(string) != "" {
+			sourceItems[item.(map[string]interface{})["path"].(string)] = item.(map[string]interface{})
+		}
+	}
 
-		path = worktree.Filesystem.Join(path)
+	// Read remote files so that drifted files show up in the plan
+	items, err = readItems(worktree.Filesystem, items)
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
-		// Create, write then close file
-		file, err := worktree.Filesystem.Create(path)
-		if err != nil {
-			return diag.Errorf("failed to create file %s: %s", path, err)
-		}
+	blocks, err := readBlocks(worktree.Filesystem, d.Get("add_block").([]interface{}))
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
-		_, err = io.WriteString(file, content)
-		if err != nil {
-			return diag.Errorf("failed to write to file %s: %s", path, err)
-		}
+	values, err := readValues(worktree.Filesystem, d.Get("set_value").([]interface{}))
+	if err != nil {
+		return diag.FromErr(err)
+	}
 
-		err = file.Close()
-		if err != nil {
-			return diag.Errorf("failed to close file %s: %s", path, err)
-		}
+	replacements, err := readReplacements(worktree.Filesystem, d.Get("replace").([]interface{}))
+	if err != nil {
+		return diag.FromErr(err)
 	}
 
-	// Check if worktree is clean
-	status, err := worktree.Status()
+	submodules, err := readSubmodules(repo, worktree, d.Get("submodule").([]interface{}))
 	if err != nil {
-		return diag.Errorf("failed to compute worktree status: %s", err)
+		return diag.FromErr(err)
 	}
-	if status.IsClean() {
-		sha, err := repo.ResolveRevision(plumbing.Revision(plumbing.HEAD))
-		if err != nil {
-			return diag.Errorf("failed to get existing commit: %s", err)
+
+	blobShas, err := readSourceBlobs(worktree.Filesystem, d.Get("add").(*schema.Set).List(), d.Get("source_blob_sha").(map[string]interface{}))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Hash remote files written from local sources
+	remoteHashes := map[string]interface{}{}
+	for path := range sourceHashes {
+		content, _, err := readFile(worktree.Filesystem, worktree.Filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return diag.FromErr(err)
 		}
 
-		d.SetId(sha.String())
-		d.Set("sha", sha.String())
-		d.Set("new", false)
+		switch item := sourceItems[path]; getItemWriteMode(item) {
+		case "create_only":
+			remoteHashes[path] = sourceHashes[path]
+			continue
+		case "append":
+			// The file drifted when lines of the local source are missing
+			lines, err := os.ReadFile(item["source"].(string))
+			if err == nil && len(missingLines(content, lines)) == 0 {
+				remoteHashes[path] = sourceHashes[path]
+			}
+			continue
+		}
 
-		return nil
+		hash := sha256.Sum256(content)
+		remoteHashes[path] = hex.EncodeToString(hash[:])
+	}
+
+	d.SetId(sha.String())
+	d.Set("sha", sha.String())
+	d.Set("new", false)
+	d.Set("add", items)
+	d.Set("add_block", blocks)
+	d.Set("set_value", values)
+	d.Set("replace", replacements)
+	d.Set("submodule", submodules)
+	d.Set("patch", readPatch(worktree.Filesystem, d.Get("patch").(string)))
+	d.Set("source_sha256", remoteHashes)
+	d.Set("source_blob_sha", blobShas)
+
+	return nil
+}
+
+func resourceCommitUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) (diags diag.Diagnostics) {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+	message := d.Get("message").(string)
+
+	if updateMessage, ok := d.GetOk("update_message"); ok {
+		message = updateMessage.(string)
 	}
 
-	// Stage worktree
-	err = worktree.AddWithOptions(&gogit.AddOptions{
-		All: true,
-	})
+	// Clone repository
+	auth, err := getAuth(d)
 	if err != nil {
-		return diag.Errorf("failed to stage worktree: %s", err)
+		return diag.Errorf("failed to prepare authentication: %s", err)
 	}
 
-	// Commit
-	commitSha, err := worktree.Commit(message, &gogit.CommitOptions{
-		Author: &object.Signature{
-			Name:  os.Getenv("GIT_AUTHOR_NAME"),
-			Email: os.Getenv("GIT_AUTHOR_EMAIL"),
-			When:  time.Now(),
-		},
-	})
+	// Wait for other operations on the branch, then start from the tip they leave
+	locks := meta.(*providerConfig).locks
+	unlock, diags := lockBranch(ctx, d, locks, url, branch, auth)
+	if diags.HasError() {
+		return diags
+	}
+	defer func() {
+		diags = append(diags, unlock(ctx)...)
+	}()
+
+	repo, worktree, sha, err := openBranch(ctx, url, branch, auth, d.Get("orphan").(bool))
 	if err != nil {
-		return diag.Errorf("failed to commit: %s", err)
+		return diag.FromErr(err)
+	}
+
+	if diags := checkParent(d, locks, url, branch, *sha); diags.HasError() {
+		return diags
 	}
 
-	// Update branch
-	branchRef := plumbing.NewBranchReferenceName(branch)
-	hashRef := plumbing.NewHashReference(branchRef, commitSha)
-	err = repo.Storer.SetReference(hashRef)
+	// Prune files
+	err = pruneFiles(worktree, d)
 	if err != nil {
-		return diag.Errorf("failed to set branch ref: %s", err)
+		return diag.FromErr(err)
 	}
 
-	// Push
-	err = repo.PushContext(ctx, &gogit.PushOptions{
-		RefSpecs: []config.RefSpec{
-			config.RefSpec(fmt.Sprintf("%s:%s", branchRef, branchRef)),
-		},
-		Auth: auth,
-	})
+	// Write files
+	written, err := writeChanges(ctx, repo, worktree, d, auth, meta.(*providerConfig).sources)
 	if err != nil {
-		return diag.Errorf("failed to push: %s", err)
+		return diag.FromErr(err)
 	}
+	sourceHashes := written.sourceHashes
+	diags = append(diags, written.warnings...)
 
-	d.SetId(commitSha.String())
-	d.Set("sha", commitSha.String())
-	d.Set("new", true)
+	// Commit then push
+	commitSha, err := commitAndPush(ctx, repo, worktree, branch, message, auth)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if !commitSha.IsZero() {
+		locks.RecordPush(url, branch, commitSha, *sha)
+	}
 
-	return nil
+	// Remember files as they were before being written, to restore them on destroy
+	blobs, err := originalBlobs(repo, *sha, d, sourceHashes)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	d.Set("original_blobs", blobs)
+	d.Set("replace_match_count", written.matchCounts)
+	d.Set("source_blob_sha", written.blobShas)
+
+	return append(diags, setCommit(d, repo, *sha, commitSha, sourceHashes)...)
 }
 
-func resourceCommitDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+func resourceCommitDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) (diags diag.Diagnostics) {
 	url := d.Get("url").(string)
 	branch := d.Get("branch").(string)
 	message := d.Get("message").(string)
-	items := d.Get("add").([]interface{})
+	items := d.Get("add").(*schema.Set).List()
 	prune := d.Get("prune").(bool)
 
 	if deleteMessage, ok := d.GetOk("delete_message"); ok {
@@ -473,98 +945,863 @@ func resourceCommitDelete(ctx context.Context, d *schema.ResourceData, meta inte
 		return diag.Errorf("failed to prepare authentication: %s", err)
 	}
 
-	repo, err := gogit.CloneContext(ctx, memory.NewStorage(), memfs.New(), &gogit.CloneOptions{
-		URL:  url,
-		Auth: auth,
-	})
+	// Wait for other operations on the branch, then start from the tip they leave
+	locks := meta.(*providerConfig).locks
+	unlock, diags := lockBranch(ctx, d, locks, url, branch, auth)
+	if diags.HasError() {
+		return diags
+	}
+	defer func() {
+		diags = append(diags, unlock(ctx)...)
+	}()
+
+	repo, worktree, sha, err := cloneBranch(ctx, url, branch, auth)
+	if err != nil && isBranchNotFound(err) {
+		// Nothing is left to delete
+		return diag.Diagnostics{
+			{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Branch %s not found", branch),
+				Detail:   fmt.Sprintf("The branch %s of %s no longer exists, there is nothing to delete: %s", branch, url, err),
+			},
+		}
+	} else if err != nil {
+		return diag.FromErr(err)
+	}
+
+	onDestroy := d.Get("on_destroy").(string)
+	if onDestroy == "" && prune {
+		onDestroy = "delete"
+	}
+
+	paths := managedPaths(items, d.Get("source_sha256").(map[string]interface{}))
+	switch onDestroy {
+	case "delete":
+		// Delete all files
+		written := map[string]bool{}
+		for _, path := range paths {
+			written[path] = true
+			path = worktree.Filesystem.Join(path)
+
+			_, err = worktree.Remove(path)
+			if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return diag.Errorf("failed to delete file %s: %s", path, err)
+			}
+		}
+
+		// Files only edited by the resource are put back as they were rather than deleted
+		var edited []string
+		for _, path := range editedPaths(d, false) {
+			if !written[path] {
+				edited = append(edited, path)
+			}
+		}
+		err = restoreFiles(repo, worktree, edited, d.Get("original_blobs").(map[string]interface{}))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	case "restore":
+		err = restoreFiles(repo, worktree, append(paths, editedPaths(d, false)...), d.Get("original_blobs").(map[string]interface{}))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// Remove blocks from the files maintained outside of Terraform
+	if onDestroy != "keep" {
+		err = removeBlocks(worktree, d.Get("add_block").([]interface{}))
+		if err != nil {
+			return diag.FromErr(err)
+		}
+	}
+
+	// Forget the paths managed by the resource in the ownership manifest
+	err = releaseOwnership(worktree, d, claimedPaths(d, false, d.Get("source_sha256").(map[string]interface{})))
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Commit then push
+	commitSha, err := commitAndPush(ctx, repo, worktree, branch, message, auth)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if !commitSha.IsZero() {
+		locks.RecordPush(url, branch, commitSha, *sha)
+	}
+
+	return nil
+}
+
+func resourceCommitImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	parts := strings.SplitN(d.Id(), "#", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("unexpected import ID %s, expected url#branch#path1,path2", d.Id())
+	}
+	url := parts[0]
+	branch := parts[1]
+	paths := strings.Split(parts[2], ",")
+
+	// Clone repository, the auth block is not available during import
+	auth, err := getImportAuth()
+	if err != nil {
+		return nil, err
+	}
+	repo, worktree, sha, err := cloneBranch(ctx, url, branch, auth)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expand directories into the files they contain
+	var files []string
+	for _, path := range paths {
+		path = strings.Trim(path, "/")
+
+		info, err := worktree.Filesystem.Lstat(worktree.Filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("file %s not found on branch %s", path, branch)
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to stat file %s: %s", path, err)
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		children, err := listFiles(worktree.Filesystem, path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list directory %s: %s", path, err)
+		}
+		files = append(files, children...)
+	}
+
+	// Populate add items from the current content of the branch
+	items := make([]interface{}, 0, len(files))
+	for _, path := range files {
+		content, mode, err := readFile(worktree.Filesystem, worktree.Filesystem.Join(path))
+		if err != nil {
+			return nil, err
+		}
+
+		item := map[string]interface{}{
+			"path":           path,
+			"content":        "",
+			"content_base64": "",
+			"source":         "",
+			"mode":           "",
+			"write_mode":     "",
+		}
+		if utf8.Valid(content) {
+			item["content"] = string(content)
+		} else {
+			item["content_base64"] = base64.StdEncoding.EncodeToString(content)
+		}
+		if mode != filemode.Regular {
+			item["mode"] = fmt.Sprintf("%o", uint32(mode))
+		}
+
+		items = append(items, item)
+	}
+
+	// Imported files are restored to their current content on destroy
+	blobs, err := treeBlobs(repo, *sha, files)
 	if err != nil {
-		return diag.Errorf("failed to clone repository: %s", err)
+		return nil, err
 	}
 
-	// Get the current worktree
-	worktree, err := repo.Worktree()
+	d.SetId(sha.String())
+	d.Set("url", url)
+	d.Set("branch", branch)
+	d.Set("message", "Committed with Terraform")
+	d.Set("add", items)
+	d.Set("original_blobs", blobs)
+	d.Set("prune", false)
+	d.Set("sha", sha.String())
+	d.Set("new", false)
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// checkParent ensures the branch tip is the expected parent, or the tip captured during plan.
+// Commits pushed in between by the provider itself, e.g. by other resources of the same apply, are tolerated.
+func checkParent(d *schema.ResourceData, locks *branchLocks, url string, branch string, sha plumbing.Hash) diag.Diagnostics {
+	expected := d.Get("expected_parent_sha").(string)
+	if expected == "" {
+		expected = d.Get("parent_sha").(string)
+	}
+
+	if expected == "" || locks.PushedSince(url, branch, plumbing.NewHash(expected), sha) {
+		return nil
+	}
+
+	return diag.Diagnostics{
+		{
+			Severity: diag.Error,
+			Summary:  fmt.Sprintf("Branch %s has moved", branch),
+			Detail:   fmt.Sprintf("The tip of branch %s is %s while %s was expected. The branch has been updated since the plan, plan again to review the new changes.", branch, sha.String(), expected),
+		},
+	}
+}
+
+// setCommit sets the resource state from the pushed commit, or from the existing
+// branch tip when there was nothing to commit
+func setCommit(d *schema.ResourceData, repo *gogit.Repository, sha plumbing.Hash, commitSha plumbing.Hash, sourceHashes map[string]interface{}) diag.Diagnostics {
+	d.Set("parent_sha", tipString(sha))
+
+	if commitSha.IsZero() && sha.IsZero() {
+		return diag.Errorf("branch does not exist and there is nothing to commit to create it")
+	}
+
+	if commitSha.IsZero() {
+		d.SetId(sha.String())
+		d.Set("sha", sha.String())
+		d.Set("new", false)
+		d.Set("changes", []map[string]interface{}{})
+		d.Set("source_sha256", sourceHashes)
+
+		return nil
+	}
+
+	changes, err := listChanges(repo, commitSha)
 	if err != nil {
-		return diag.Errorf("failed to get worktree: %s", err)
+		return diag.FromErr(err)
 	}
 
-	// Resolve then checkout the specified branch
-	sha, err := repo.ResolveRevision(plumbing.Revision(plumbing.NewRemoteReferenceName("origin", branch)))
-	if err != nil && errors.Is(err, plumbing.ErrReferenceNotFound) {
-		sha, err = repo.ResolveRevision(plumbing.Revision(plumbing.NewBranchReferenceName(branch)))
+	d.SetId(commitSha.String())
+	d.Set("sha", commitSha.String())
+	d.Set("new", true)
+	d.Set("changes", changes)
+	d.Set("source_sha256", sourceHashes)
+
+	return nil
+}
+
+// originalBlobs returns the blobs of the managed files as they were before the resource first wrote them.
+// Files created by the resource have no original blob.
+func originalBlobs(repo *gogit.Repository, sha plumbing.Hash, d resourceGetter, sourceHashes map[string]interface{}) (map[string]interface{}, error) {
+	oldItems, _ := d.GetChange("add")
+	oldHashes, _ := d.GetChange("source_sha256")
+	oldBlobs, _ := d.GetChange("original_blobs")
+
+	managed := map[string]bool{}
+	for _, path := range append(managedPaths(oldItems.(*schema.Set).List(), oldHashes.(map[string]interface{})), editedPaths(d, true)...) {
+		managed[path] = true
 	}
+
+	blobs := map[string]interface{}{}
+	var added []string
+	for _, path := range append(managedPaths(d.Get("add").(*schema.Set).List(), sourceHashes), editedPaths(d, false)...) {
+		if managed[path] {
+			// The branch already holds content written by the resource
+			if blob, ok := oldBlobs.(map[string]interface{})[path]; ok {
+				blobs[path] = blob
+			}
+			continue
+		}
+
+		added = append(added, path)
+	}
+
+	addedBlobs, err := treeBlobs(repo, sha, added)
 	if err != nil {
-		return diag.Errorf("failed to resolve branch %s: %s", branch, err)
+		return nil, err
 	}
+	for path, blob := range addedBlobs {
+		blobs[path] = blob
+	}
+
+	return blobs, nil
+}
 
-	err = worktree.Checkout(&gogit.CheckoutOptions{
-		Hash:  *sha,
-		Force: true,
-	})
+// treeBlobs returns the blobs of the files existing in the tree of a commit
+func treeBlobs(repo *gogit.Repository, sha plumbing.Hash, paths []string) (map[string]interface{}, error) {
+	// A branch to be created has no files yet
+	if sha.IsZero() {
+		return map[string]interface{}{}, nil
+	}
+
+	commit, err := repo.CommitObject(sha)
 	if err != nil {
-		return diag.Errorf("failed to checkout hash %s: %s", sha.String(), err)
+		return nil, fmt.Errorf("failed to get commit %s: %w", sha.String(), err)
 	}
 
-	// Prune files
-	if prune {
-		for _, item := range items {
-			path := item.(map[string]interface{})["path"].(string)
-			path = worktree.Filesystem.Join(path)
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of commit %s: %w", sha.String(), err)
+	}
+
+	blobs := map[string]interface{}{}
+	for _, path := range paths {
+		file, err := tree.File(path)
+		if err != nil && errors.Is(err, object.ErrFileNotFound) {
+			// Submodules are recorded with the commit of their gitlink
+			if entry, err := tree.FindEntry(path); err == nil && entry.Mode == filemode.Submodule {
+				blobs[path] = fmt.Sprintf("%s:%o", entry.Hash.String(), uint32(entry.Mode))
+			}
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get file %s: %w", path, err)
+		}
+
+		blobs[path] = fmt.Sprintf("%s:%o", file.Hash.String(), uint32(file.Mode))
+	}
+
+	return blobs, nil
+}
+
+// parseOriginalBlob returns the SHA and mode of an original blob recorded as sha:mode,
+// blobs recorded without their mode are regular files
+func parseOriginalBlob(blob string) (plumbing.Hash, filemode.FileMode, error) {
+	parts := strings.SplitN(blob, ":", 2)
+	if len(parts) == 1 {
+		return plumbing.NewHash(parts[0]), filemode.Regular, nil
+	}
+
+	mode, err := filemode.New(parts[1])
+	if err != nil {
+		return plumbing.ZeroHash, filemode.Empty, fmt.Errorf("failed to parse mode of original blob %s: %s", blob, err)
+	}
+
+	return plumbing.NewHash(parts[0]), mode, nil
+}
+
+// restoreFiles writes back the original blob of each file, deleting files which had none.
+// Submodules are pointed back to their original commit, or removed when they had none.
+func restoreFiles(repo *gogit.Repository, worktree *gogit.Worktree, paths []string, blobs map[string]interface{}) error {
+	for _, path := range paths {
+		path = worktree.Filesystem.Join(path)
+
+		blob, ok := blobs[path]
+		if !ok {
+			submodule, err := isSubmodule(repo, path)
+			if err != nil {
+				return err
+			}
+			if submodule {
+				err = restoreSubmodule(repo, worktree, path, plumbing.ZeroHash)
+				if err != nil {
+					return err
+				}
+				continue
+			}
 
-			// Delete all files
 			_, err = worktree.Remove(path)
 			if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
-				return diag.Errorf("failed to delete file %s: %s", path, err)
+				return fmt.Errorf("failed to delete file %s: %s", path, err)
+			}
+			continue
+		}
+
+		sha, mode, err := parseOriginalBlob(blob.(string))
+		if err != nil {
+			return err
+		}
+		if mode == filemode.Submodule {
+			err = restoreSubmodule(repo, worktree, path, sha)
+			if err != nil {
+				return err
 			}
+			continue
 		}
+
+		blobObject, err := repo.BlobObject(sha)
+		if err != nil {
+			return fmt.Errorf("failed to get original blob %s of file %s: %w", sha.String(), path, err)
+		}
+
+		reader, err := blobObject.Reader()
+		if err != nil {
+			return fmt.Errorf("failed to read original blob %s of file %s: %w", sha.String(), path, err)
+		}
+
+		if mode == filemode.Symlink {
+			var target []byte
+			target, err = io.ReadAll(reader)
+			if err == nil {
+				err = writeSymlink(worktree.Filesystem, path, string(target))
+			}
+		} else {
+			var perm os.FileMode
+			perm, err = mode.ToOSFileMode()
+			if err == nil {
+				err = writeFile(worktree.Filesystem, path, reader, perm)
+			}
+		}
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("failed to restore file %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+// pruneFiles deletes files previously written by the resource when prune is enabled
+func pruneFiles(worktree *gogit.Worktree, d resourceGetter) error {
+	prune := d.Get("prune").(bool)
+	if !prune || !(d.HasChange("add") || d.HasChange("source_sha256")) {
+		return nil
+	}
+
+	oldItems, newItems := d.GetChange("add")
+	oldSourceHashes, newSourceHashes := d.GetChange("source_sha256")
+
+	// Only delete paths which are no longer written
+	written := map[string]bool{}
+	for _, item := range newItems.(*schema.Set).List() {
+		written[path.Clean(item.(map[string]interface{})["path"].(string))] = true
 	}
+	for path := range newSourceHashes.(map[string]interface{}) {
+		written[path] = true
+	}
+
+	var paths []string
+	for _, item := range oldItems.(*schema.Set).List() {
+		path := path.Clean(item.(map[string]interface{})["path"].(string))
+		if !written[path] {
+			paths = append(paths, path)
+		}
+	}
+	for path := range oldSourceHashes.(map[string]interface{}) {
+		if !written[path] {
+			paths = append(paths, path)
+		}
+	}
+
+	for _, path := range paths {
+		path = worktree.Filesystem.Join(path)
+
+		// Delete old files
+		_, err := worktree.Remove(path)
+		if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+			return fmt.Errorf("failed to delete file %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+// writtenChanges is what writing the changes of a resource to a worktree produced
+type writtenChanges struct {
+	sourceHashes map[string]interface{}
+	blobShas     map[string]interface{}
+	matchCounts  map[string]interface{}
+	warnings     diag.Diagnostics
+}
 
-	// Check if worktree is clean
-	status, err := worktree.Status()
+// writeChanges patches, moves, removes then writes files of the resource in the worktree
+func writeChanges(ctx context.Context, repo *gogit.Repository, worktree *gogit.Worktree, d resourceGetter, auth transport.AuthMethod, sources *sourceCache) (*writtenChanges, error) {
+	// Apply the patch first as its context is checked against the branch tip
+	err := writePatch(worktree, d.Get("patch").(string))
 	if err != nil {
-		return diag.Errorf("failed to compute worktree status: %s", err)
+		return nil, err
 	}
-	if status.IsClean() {
-		return nil
+
+	err = moveFiles(worktree, d.Get("move").([]interface{}))
+	if err != nil {
+		return nil, err
 	}
 
-	// Stage worktree
-	err = worktree.AddWithOptions(&gogit.AddOptions{
-		All: true,
-	})
+	err = removeFiles(worktree, d.Get("remove").([]interface{}))
 	if err != nil {
-		return diag.Errorf("failed to stage worktree: %s", err)
+		return nil, err
 	}
 
-	// Commit
-	commitSha, err := worktree.Commit(message, &gogit.CommitOptions{
-		Author: &object.Signature{
-			Name:  os.Getenv("GIT_AUTHOR_NAME"),
-			Email: os.Getenv("GIT_AUTHOR_EMAIL"),
-			When:  time.Now(),
-		},
-	})
+	// Fetch files copied from other refs or repositories
+	items, blobShas, err := resolveItemSources(ctx, sources, d.Get("add").(*schema.Set).List(), d.Get("url").(string), auth)
 	if err != nil {
-		return diag.Errorf("failed to commit: %s", err)
+		return nil, err
 	}
 
-	// Update branch
-	branchRef := plumbing.NewBranchReferenceName(branch)
-	hashRef := plumbing.NewHashReference(branchRef, commitSha)
-	err = repo.Storer.SetReference(hashRef)
+	sourceHashes, err := writeItems(worktree.Filesystem, items)
 	if err != nil {
-		return diag.Errorf("failed to set branch ref: %s", err)
+		return nil, err
 	}
 
-	// Push
-	err = repo.PushContext(ctx, &gogit.PushOptions{
-		RefSpecs: []config.RefSpec{
-			config.RefSpec(fmt.Sprintf("%s:%s", branchRef, branchRef)),
-		},
-		Auth: auth,
-	})
+	// Mirror directories
+	directoryHashes, err := writeDirectories(worktree, d.Get("add_directory").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	for path, hash := range directoryHashes {
+		sourceHashes[path] = hash
+	}
+
+	// Edit blocks within files
+	err = writeBlocks(worktree, d)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set values within structured files
+	err = writeValues(worktree.Filesystem, d.Get("set_value").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Substitute patterns within files
+	matchCounts, warnings, err := writeReplacements(worktree.Filesystem, d)
 	if err != nil {
-		return diag.Errorf("failed to push: %s", err)
+		return nil, err
+	}
+
+	// Point submodules to their commit
+	err = writeSubmodules(repo, worktree, d.Get("submodule").([]interface{}))
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the managed paths in the ownership manifest
+	err = updateManifest(worktree, d, sourceHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &writtenChanges{
+		sourceHashes: sourceHashes,
+		blobShas:     blobShas,
+		matchCounts:  matchCounts,
+		warnings:     warnings,
+	}, nil
+}
+
+func moveFiles(worktree *gogit.Worktree, moves []interface{}) error {
+	for _, move := range moves {
+		from := path.Clean(move.(map[string]interface{})["from"].(string))
+		to := path.Clean(move.(map[string]interface{})["to"].(string))
+
+		info, err := worktree.Filesystem.Lstat(from)
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			// Moving again is a no-op once the destination exists
+			if _, err := worktree.Filesystem.Lstat(to); err == nil {
+				continue
+			}
+			return fmt.Errorf("failed to move file %s: %w", from, err)
+		} else if err != nil {
+			return fmt.Errorf("failed to stat file %s: %w", from, err)
+		}
+
+		// Move directories file by file
+		files := []string{from}
+		if info.IsDir() {
+			files, err = listFiles(worktree.Filesystem, from)
+			if err != nil {
+				return fmt.Errorf("failed to list directory %s: %w", from, err)
+			}
+		}
+
+		for _, file := range files {
+			destination := to + strings.TrimPrefix(file, from)
+
+			err = copyFile(worktree.Filesystem, file, destination)
+			if err != nil {
+				return err
+			}
+
+			_, err = worktree.Remove(file)
+			if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return fmt.Errorf("failed to delete file %s: %w", file, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func removeFiles(worktree *gogit.Worktree, removals []interface{}) error {
+	files, err := listFiles(worktree.Filesystem, "")
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
+	}
+
+	for _, removal := range removals {
+		pattern := path.Clean(removal.(map[string]interface{})["path"].(string))
+
+		for _, file := range files {
+			// Match the exact path, files below a directory or a glob pattern
+			if file != pattern && !strings.HasPrefix(file, pattern+"/") && !matchPathGlob(pattern, file) {
+				continue
+			}
+
+			_, err = worktree.Remove(file)
+			if err != nil && !errors.Is(err, index.ErrEntryNotFound) && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete file %s: %w", file, err)
+			}
+		}
 	}
 
 	return nil
 }
+
+// readItems replaces the content of add items with the remote content, dropping deleted files
+func readItems(filesystem billy.Filesystem, items []interface{}) ([]interface{}, error) {
+	remoteItems := make([]interface{}, 0, len(items))
+
+	for _, item := range items {
+		path := item.(map[string]interface{})["path"].(string)
+
+		content, mode, err := readFile(filesystem, filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		remoteItem := map[string]interface{}{}
+		for key, value := range item.(map[string]interface{}) {
+			remoteItem[key] = value
+		}
+
+		switch getItemWriteMode(remoteItem) {
+		case "create_only":
+			// Seeded files may be edited freely
+			remoteItems = append(remoteItems, remoteItem)
+			continue
+		case "append":
+			// Only lines missing from the file are drift, sources are diffed with their hash
+			if !hasItemSource(remoteItem) {
+				if remoteItem["content_base64"].(string) != "" {
+					lines, _ := base64.StdEncoding.DecodeString(remoteItem["content_base64"].(string))
+					if missing := missingLines(content, lines); len(missing) > 0 {
+						remoteItem["content_base64"] = base64.StdEncoding.EncodeToString([]byte(removeLines(string(lines), missing)))
+					}
+				} else {
+					lines := remoteItem["content"].(string)
+					if missing := missingLines(content, []byte(lines)); len(missing) > 0 {
+						remoteItem["content"] = removeLines(lines, missing)
+					}
+				}
+			}
+		default:
+			// Sources are diffed with their hash
+			if !hasItemSource(remoteItem) {
+				if remoteItem["content_base64"].(string) != "" {
+					remoteItem["content_base64"] = base64.StdEncoding.EncodeToString(content)
+				} else {
+					remoteItem["content"] = string(content)
+				}
+			}
+		}
+
+		if remoteItem["mode"].(string) != "" || mode != filemode.Regular {
+			remoteItem["mode"] = fmt.Sprintf("%o", uint32(mode))
+		}
+
+		remoteItems = append(remoteItems, remoteItem)
+	}
+
+	return remoteItems, nil
+}
+
+func writeItems(filesystem billy.Filesystem, items []interface{}) (map[string]interface{}, error) {
+	sourceHashes := map[string]interface{}{}
+
+	for _, item := range items {
+		path := item.(map[string]interface{})["path"].(string)
+		source := item.(map[string]interface{})["source"].(string)
+		writeMode := getItemWriteMode(item.(map[string]interface{}))
+
+		if writeMode == "create_only" {
+			_, err := filesystem.Lstat(filesystem.Join(path))
+			if err == nil {
+				// Seeded files are left to their maintainers
+				if source != "" {
+					hash, err := hashFile(source)
+					if err != nil {
+						return nil, fmt.Errorf("failed to hash source %s: %s", source, err)
+					}
+					sourceHashes[path] = hash
+				}
+				continue
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("failed to stat file %s: %s", path, err)
+			}
+		}
+
+		content, err := openItemContent(item.(map[string]interface{}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open content of file %s: %s", path, err)
+		}
+
+		// Stream content into the worktree while hashing it
+		hash := sha256.New()
+		reader := io.TeeReader(content, hash)
+		switch mode := getItemMode(item.(map[string]interface{})); {
+		case writeMode == "append":
+			if mode == filemode.Symlink {
+				content.Close()
+				return nil, fmt.Errorf("failed to append lines to file %s: symlinks cannot be appended to", path)
+			}
+
+			// Keep the mode of an existing file unless set
+			if item.(map[string]interface{})["mode"].(string) == "" {
+				mode = filemode.Empty
+			}
+			err = appendLines(filesystem, filesystem.Join(path), reader, mode)
+		case mode == filemode.Symlink:
+			target, err := io.ReadAll(reader)
+			if err != nil {
+				content.Close()
+				return nil, fmt.Errorf("failed to read symlink target %s: %s", path, err)
+			}
+			err = writeSymlink(filesystem, filesystem.Join(path), string(target))
+		case mode == filemode.Executable:
+			err = writeFile(filesystem, filesystem.Join(path), reader, 0755)
+		default:
+			err = writeFile(filesystem, filesystem.Join(path), reader, 0644)
+		}
+		content.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		if source != "" {
+			sourceHashes[path] = hex.EncodeToString(hash.Sum(nil))
+		}
+	}
+
+	return sourceHashes, nil
+}
+
+func writeDirectories(worktree *gogit.Worktree, directories []interface{}) (map[string]interface{}, error) {
+	sourceHashes := map[string]interface{}{}
+
+	for _, directory := range directories {
+		destination := strings.TrimPrefix(path.Clean("/"+directory.(map[string]interface{})["destination"].(string)), "/")
+		exclusive := directory.(map[string]interface{})["exclusive"].(bool)
+
+		files, err := listDirectoryFiles(directory.(map[string]interface{}))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list directory: %s", err)
+		}
+
+		mirrored := make(map[string]bool, len(files))
+		for _, file := range files {
+			item := map[string]interface{}{
+				"path":           file.path,
+				"content":        "",
+				"content_base64": "",
+				"source":         file.source,
+				"mode":           "100644",
+			}
+			if file.executable {
+				item["mode"] = "100755"
+			}
+
+			hashes, err := writeItems(worktree.Filesystem, []interface{}{item})
+			if err != nil {
+				return nil, err
+			}
+
+			sourceHashes[file.path] = hashes[file.path]
+			mirrored[file.path] = true
+		}
+
+		if !exclusive {
+			continue
+		}
+
+		// Delete files under the destination that no longer exist locally
+		paths, err := listFiles(worktree.Filesystem, destination)
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range paths {
+			name := strings.TrimPrefix(file, destination+"/")
+			if destination == "" {
+				name = file
+			}
+			if mirrored[file] || !matchDirectoryFilters(directory.(map[string]interface{}), name) {
+				continue
+			}
+
+			_, err = worktree.Remove(file)
+			if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+				return nil, fmt.Errorf("failed to delete file %s: %s", file, err)
+			}
+		}
+	}
+
+	return sourceHashes, nil
+}
+
+// resourceCommitV0 is the schema of git_commit where add was an ordered list
+func resourceCommitV0() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"url": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"branch": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"message": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"update_message": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"delete_message": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"add": {
+				Type:     schema.TypeList,
+				Required: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"path": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"content": {
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+			"prune": {
+				Type:     schema.TypeBool,
+				Optional: true,
+			},
+			"auth": authSchema(),
+
+			"sha": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"new": {
+				Type:     schema.TypeBool,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceCommitStateUpgradeV0(ctx context.Context, rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	items, _ := rawState["add"].([]interface{})
+
+	// Later items used to overwrite earlier items with the same path
+	indexes := map[string]int{}
+	upgraded := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		path, _ := item.(map[string]interface{})["path"].(string)
+
+		if i, ok := indexes[path]; ok {
+			upgraded[i] = item
+			continue
+		}
+
+		indexes[path] = len(upgraded)
+		upgraded = append(upgraded, item)
+	}
+	rawState["add"] = upgraded
+
+	return rawState, nil
+}
@@ -0,0 +1,240 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func resourceRepositoryFile() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceRepositoryFileCreate,
+		ReadContext:   resourceRepositoryFileRead,
+		UpdateContext: resourceRepositoryFileUpdate,
+		DeleteContext: resourceRepositoryFileDelete,
+
+		Importer: &schema.ResourceImporter{
+			StateContext: resourceRepositoryFileImport,
+		},
+
+		Schema: map[string]*schema.Schema{
+			"url": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.IsURLWithScheme([]string{"http", "https", "ssh"}),
+			},
+			"branch": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"path": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateRepositoryPath,
+			},
+			"content": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"content_base64"},
+			},
+			"content_base64": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				ValidateFunc:  validation.StringIsBase64,
+				ConflictsWith: []string{"content"},
+			},
+			"mode": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validation.StringInSlice([]string{"100644", "100755", "120000"}, false),
+			},
+			"message": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "Committed with Terraform",
+			},
+			"update_message": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"delete_message": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"auth": authSchema(),
+
+			"sha": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceRepositoryFileCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+	path := d.Get("path").(string)
+	message := d.Get("message").(string)
+
+	auth, err := getAuth(d)
+	if err != nil {
+		return diag.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	// Commit along with other files of the same branch
+	sha, err := meta.(*providerConfig).batcher.Commit(ctx, url, branch, auth, fileChange{
+		item:    getRepositoryFileItem(d),
+		message: message,
+	})
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.SetId(fmt.Sprintf("%s#%s#%s", url, branch, path))
+	d.Set("sha", sha.String())
+
+	return nil
+}
+
+func resourceRepositoryFileRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+
+	// Clone repository
+	auth, err := getReadAuth(d)
+	if err != nil {
+		return diag.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	_, worktree, _, err := cloneBranch(ctx, url, branch, auth)
+	if err != nil && isBranchNotFound(err) {
+		// The file is gone along with the branch, it will be created again
+		d.SetId("")
+		return diag.Diagnostics{
+			{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Branch %s not found", branch),
+				Detail:   fmt.Sprintf("The branch %s of %s no longer exists, the file is removed from the state: %s", branch, url, err),
+			},
+		}
+	} else if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Read remote file so that drift shows up in the plan
+	items, err := readItems(worktree.Filesystem, []interface{}{getRepositoryFileItem(d)})
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if len(items) == 0 {
+		d.SetId("")
+		return nil
+	}
+
+	item := items[0].(map[string]interface{})
+	d.Set("content", item["content"])
+	d.Set("content_base64", item["content_base64"])
+	d.Set("mode", item["mode"])
+
+	return nil
+}
+
+func resourceRepositoryFileUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+	message := d.Get("message").(string)
+
+	if updateMessage, ok := d.GetOk("update_message"); ok {
+		message = updateMessage.(string)
+	}
+
+	auth, err := getAuth(d)
+	if err != nil {
+		return diag.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	// Commit along with other files of the same branch
+	sha, err := meta.(*providerConfig).batcher.Commit(ctx, url, branch, auth, fileChange{
+		item:    getRepositoryFileItem(d),
+		message: message,
+	})
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	d.Set("sha", sha.String())
+
+	return nil
+}
+
+func resourceRepositoryFileDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+	message := d.Get("message").(string)
+
+	if deleteMessage, ok := d.GetOk("delete_message"); ok {
+		message = deleteMessage.(string)
+	} else if updateMessage, ok := d.GetOk("update_message"); ok {
+		message = updateMessage.(string)
+	}
+
+	auth, err := getAuth(d)
+	if err != nil {
+		return diag.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	// Commit along with other files of the same branch
+	_, err = meta.(*providerConfig).batcher.Commit(ctx, url, branch, auth, fileChange{
+		item:    getRepositoryFileItem(d),
+		delete:  true,
+		message: message,
+	})
+	if err != nil && isBranchNotFound(err) {
+		// Nothing is left to delete
+		return diag.Diagnostics{
+			{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Branch %s not found", branch),
+				Detail:   fmt.Sprintf("The branch %s of %s no longer exists, there is nothing to delete: %s", branch, url, err),
+			},
+		}
+	} else if err != nil {
+		return diag.FromErr(err)
+	}
+
+	return nil
+}
+
+func resourceRepositoryFileImport(ctx context.Context, d *schema.ResourceData, meta interface{}) ([]*schema.ResourceData, error) {
+	parts := strings.SplitN(d.Id(), "#", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, fmt.Errorf("unexpected import ID %s, expected url#branch#path", d.Id())
+	}
+
+	d.Set("url", parts[0])
+	d.Set("branch", parts[1])
+	d.Set("path", parts[2])
+	d.Set("message", "Committed with Terraform")
+
+	return []*schema.ResourceData{d}, nil
+}
+
+// getRepositoryFileItem returns the file as an add item of git_commit
+func getRepositoryFileItem(d *schema.ResourceData) map[string]interface{} {
+	return map[string]interface{}{
+		"path":           d.Get("path").(string),
+		"content":        d.Get("content").(string),
+		"content_base64": d.Get("content_base64").(string),
+		"source":         "",
+		"mode":           d.Get("mode").(string),
+		"write_mode":     "",
+	}
+}
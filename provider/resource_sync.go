@@ -0,0 +1,474 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"reflect"
+	"strings"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/customdiff"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func resourceSync() *schema.Resource {
+	return &schema.Resource{
+		CreateContext: resourceSyncCreate,
+		ReadContext:   resourceSyncRead,
+		UpdateContext: resourceSyncUpdate,
+		DeleteContext: resourceSyncDelete,
+
+		CustomizeDiff: customdiff.All(
+			resourceSyncDiffExclusive,
+			resourceSyncDiffSource,
+		),
+
+		Schema: map[string]*schema.Schema{
+			"source_url": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ValidateFunc: validation.IsURLWithScheme([]string{"http", "https", "ssh"}),
+			},
+			"source_ref": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"source_path": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "",
+				ValidateFunc: validation.Any(validation.StringIsEmpty, validateRepositoryPath),
+			},
+			"url": {
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validation.IsURLWithScheme([]string{"http", "https", "ssh"}),
+			},
+			"branch": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"path": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				ForceNew:     true,
+				Default:      "",
+				ValidateFunc: validation.Any(validation.StringIsEmpty, validateRepositoryPath),
+			},
+			"exclusive": {
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"message": {
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "Synced with Terraform",
+			},
+			"delete_message": {
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"ownership": ownershipSchema(),
+			"lock":      lockSchema(),
+			"auth":      authSchema(),
+
+			"sha": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"source_sha": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"files": {
+				Type:     schema.TypeMap,
+				Computed: true,
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+		},
+	}
+}
+
+func resourceSyncDiffExclusive(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	// Other files of the whole repository would be deleted
+	if isConfigKnown(d, "path") && d.Get("exclusive").(bool) && d.Get("path").(string) == "" {
+		return fmt.Errorf("path must be set when exclusive is enabled, only the files below it are synced exclusively")
+	}
+
+	return nil
+}
+
+func resourceSyncDiffSource(ctx context.Context, d *schema.ResourceDiff, meta interface{}) error {
+	for _, key := range []string{"source_url", "source_ref", "source_path", "path"} {
+		if !isConfigKnown(d, key) {
+			for _, key := range []string{"sha", "source_sha", "files"} {
+				err := d.SetNewComputed(key)
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		}
+	}
+
+	auth, err := getAuth(d)
+	if err != nil {
+		return fmt.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	// Fetch the source so that its changes, or files changed in the target, show up in the plan
+	commit, err := fetchSourceCommit(ctx, meta.(*providerConfig).sources, d.Get("source_url").(string), getSyncSourceRef(d), auth)
+	if err != nil {
+		return err
+	}
+	files, err := listSyncFiles(commit, d.Get("source_path").(string), d.Get("path").(string))
+	if err != nil {
+		return err
+	}
+
+	if d.Id() != "" && reflect.DeepEqual(d.Get("files").(map[string]interface{}), hashSyncFiles(files)) {
+		return nil
+	}
+
+	err = d.SetNew("files", hashSyncFiles(files))
+	if err != nil {
+		return err
+	}
+	err = d.SetNew("source_sha", commit.Hash.String())
+	if err != nil {
+		return err
+	}
+
+	return d.SetNewComputed("sha")
+}
+
+func resourceSyncCreate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	diags := resourceSyncApply(ctx, d, meta)
+	if diags.HasError() {
+		return diags
+	}
+
+	d.SetId(fmt.Sprintf("%s#%s#%s", d.Get("url").(string), d.Get("branch").(string), d.Get("path").(string)))
+
+	return diags
+}
+
+func resourceSyncRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+	targetPath := d.Get("path").(string)
+
+	// Clone repository
+	auth, err := getAuth(d)
+	if err != nil {
+		return diag.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	_, worktree, _, err := cloneBranch(ctx, url, branch, auth)
+	if err != nil && isBranchNotFound(err) {
+		// The synced files are gone along with the branch, they will be synced again
+		d.SetId("")
+		return diag.Diagnostics{
+			{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Branch %s not found", branch),
+				Detail:   fmt.Sprintf("The branch %s of %s no longer exists, the sync is removed from the state: %s", branch, url, err),
+			},
+		}
+	} else if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Hash the synced files as found in the target so that drift shows up in the plan
+	paths := []string{}
+	for path := range d.Get("files").(map[string]interface{}) {
+		paths = append(paths, path)
+	}
+	if d.Get("exclusive").(bool) {
+		// Files added next to the synced ones are drift too
+		targetFiles, err := listFiles(worktree.Filesystem, targetPath)
+		if err != nil {
+			return diag.Errorf("failed to list files: %s", err)
+		}
+		paths = append(paths, targetFiles...)
+	}
+
+	files := map[string]interface{}{}
+	for _, path := range paths {
+		content, mode, err := readFile(worktree.Filesystem, worktree.Filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return diag.FromErr(err)
+		}
+
+		files[path] = syncFileHash(plumbing.ComputeHash(plumbing.BlobObject, content), mode)
+	}
+
+	d.Set("files", files)
+
+	return nil
+}
+
+func resourceSyncUpdate(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	return resourceSyncApply(ctx, d, meta)
+}
+
+func resourceSyncDelete(ctx context.Context, d *schema.ResourceData, meta interface{}) (diags diag.Diagnostics) {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+	message := d.Get("message").(string)
+
+	if deleteMessage, ok := d.GetOk("delete_message"); ok {
+		message = deleteMessage.(string)
+	}
+
+	// Clone repository
+	auth, err := getAuth(d)
+	if err != nil {
+		return diag.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	// Wait for other operations on the branch, then start from the tip they leave
+	locks := meta.(*providerConfig).locks
+	unlock, diags := lockBranch(ctx, d, locks, url, branch, auth)
+	if diags.HasError() {
+		return diags
+	}
+	defer func() {
+		diags = append(diags, unlock(ctx)...)
+	}()
+
+	repo, worktree, sha, err := cloneBranch(ctx, url, branch, auth)
+	if err != nil && isBranchNotFound(err) {
+		// Nothing is left to delete
+		return diag.Diagnostics{
+			{
+				Severity: diag.Warning,
+				Summary:  fmt.Sprintf("Branch %s not found", branch),
+				Detail:   fmt.Sprintf("The branch %s of %s no longer exists, there is nothing to delete: %s", branch, url, err),
+			},
+		}
+	} else if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Delete the synced files
+	paths := []string{}
+	for path := range d.Get("files").(map[string]interface{}) {
+		_, err := worktree.Remove(path)
+		if err != nil && !errors.Is(err, index.ErrEntryNotFound) && !errors.Is(err, fs.ErrNotExist) {
+			return diag.Errorf("failed to delete file %s: %s", path, err)
+		}
+		paths = append(paths, path)
+	}
+
+	// Forget the synced files in the ownership manifest
+	err = releaseOwnership(worktree, d, paths)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	commitSha, err := commitAndPush(ctx, repo, worktree, branch, message, auth)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if !commitSha.IsZero() {
+		locks.RecordPush(url, branch, commitSha, *sha)
+	}
+
+	return nil
+}
+
+// resourceSyncApply copies the source files into the target then commits them, referencing the source commit in the message
+func resourceSyncApply(ctx context.Context, d *schema.ResourceData, meta interface{}) (diags diag.Diagnostics) {
+	url := d.Get("url").(string)
+	branch := d.Get("branch").(string)
+	targetPath := d.Get("path").(string)
+	sourceURL := d.Get("source_url").(string)
+
+	// Clone repository
+	auth, err := getAuth(d)
+	if err != nil {
+		return diag.Errorf("failed to prepare authentication: %s", err)
+	}
+
+	// Wait for other operations on the branch, then start from the tip they leave
+	locks := meta.(*providerConfig).locks
+	unlock, diags := lockBranch(ctx, d, locks, url, branch, auth)
+	if diags.HasError() {
+		return diags
+	}
+	defer func() {
+		diags = append(diags, unlock(ctx)...)
+	}()
+
+	repo, worktree, sha, err := openBranch(ctx, url, branch, auth, false)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	commit, err := fetchSourceCommit(ctx, meta.(*providerConfig).sources, sourceURL, getSyncSourceRef(d), auth)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	files, err := listSyncFiles(commit, d.Get("source_path").(string), targetPath)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Delete files previously synced which are gone from the source, and any other file in exclusive mode
+	oldFiles, _ := d.GetChange("files")
+	stale := []string{}
+	for path := range oldFiles.(map[string]interface{}) {
+		stale = append(stale, path)
+	}
+	if d.Get("exclusive").(bool) {
+		targetFiles, err := listFiles(worktree.Filesystem, targetPath)
+		if err != nil {
+			return diag.Errorf("failed to list files: %s", err)
+		}
+		stale = append(stale, targetFiles...)
+	}
+	for _, path := range stale {
+		if _, ok := files[path]; ok {
+			continue
+		}
+
+		_, err := worktree.Remove(path)
+		if err != nil && !errors.Is(err, index.ErrEntryNotFound) && !errors.Is(err, fs.ErrNotExist) {
+			return diag.Errorf("failed to delete file %s: %s", path, err)
+		}
+	}
+
+	paths := []string{}
+	for path, file := range files {
+		err := writeSyncFile(worktree.Filesystem, path, file)
+		if err != nil {
+			return diag.FromErr(err)
+		}
+		paths = append(paths, path)
+	}
+
+	// Claim the synced files in the ownership manifest
+	oldPaths := []string{}
+	for path := range oldFiles.(map[string]interface{}) {
+		oldPaths = append(oldPaths, path)
+	}
+	err = updateOwnership(worktree, d, oldPaths, paths)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	// Reference the source commit so that the origin of the files can be traced
+	message := fmt.Sprintf("%s\n\nSource-Repository: %s\nSource-Commit: %s", strings.TrimRight(d.Get("message").(string), "\n"), sourceURL, commit.Hash.String())
+
+	commitSha, err := commitAndPush(ctx, repo, worktree, branch, message, auth)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+	if !commitSha.IsZero() {
+		locks.RecordPush(url, branch, commitSha, *sha)
+	} else if sha.IsZero() {
+		return diag.Errorf("branch %s does not exist and there is nothing to commit to create it", branch)
+	} else {
+		commitSha = *sha
+	}
+
+	d.Set("sha", commitSha.String())
+	d.Set("source_sha", commit.Hash.String())
+	d.Set("files", hashSyncFiles(files))
+
+	return nil
+}
+
+// getSyncSourceRef returns the ref of the source to sync, the default branch when unset
+func getSyncSourceRef(d resourceGetter) string {
+	if ref := d.Get("source_ref").(string); ref != "" {
+		return ref
+	}
+
+	return "HEAD"
+}
+
+// listSyncFiles lists the files below the source path of a commit, keyed by their path in the target
+func listSyncFiles(commit *object.Commit, sourcePath string, targetPath string) (map[string]*object.File, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of commit %s: %w", commit.Hash.String(), err)
+	}
+
+	if sourcePath != "" {
+		tree, err = tree.Tree(sourcePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get directory %s of commit %s: %w", sourcePath, commit.Hash.String(), err)
+		}
+	}
+
+	files := map[string]*object.File{}
+	err = tree.Files().ForEach(func(file *object.File) error {
+		files[path.Join(targetPath, file.Name)] = file
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files of commit %s: %w", commit.Hash.String(), err)
+	}
+
+	return files, nil
+}
+
+func hashSyncFiles(files map[string]*object.File) map[string]interface{} {
+	hashes := make(map[string]interface{}, len(files))
+	for path, file := range files {
+		hashes[path] = syncFileHash(file.Hash, file.Mode)
+	}
+
+	return hashes
+}
+
+// syncFileHash returns the blob SHA of a synced file along with its mode as written by writeSyncFile, e.g. sha:100755
+func syncFileHash(hash plumbing.Hash, mode filemode.FileMode) string {
+	if mode != filemode.Symlink && mode != filemode.Executable {
+		mode = filemode.Regular
+	}
+
+	return fmt.Sprintf("%s:%o", hash.String(), uint32(mode))
+}
+
+// writeSyncFile writes a source file into the worktree, keeping its executable bit or symlink mode
+func writeSyncFile(filesystem billy.Filesystem, path string, file *object.File) error {
+	reader, err := file.Reader()
+	if err != nil {
+		return fmt.Errorf("failed to read source file %s: %s", file.Name, err)
+	}
+	defer reader.Close()
+
+	switch file.Mode {
+	case filemode.Symlink:
+		target, err := io.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink target %s: %s", file.Name, err)
+		}
+		return writeSymlink(filesystem, filesystem.Join(path), string(target))
+	case filemode.Executable:
+		return writeFile(filesystem, filesystem.Join(path), reader, 0755)
+	default:
+		return writeFile(filesystem, filesystem.Join(path), reader, 0644)
+	}
+}
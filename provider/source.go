@@ -0,0 +1,284 @@
+package provider
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-billy/v5"
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+)
+
+// hasRemoteSource returns whether the content of an add item is copied from another ref or repository
+func hasRemoteSource(item map[string]interface{}) bool {
+	sourceRef, _ := item["source_ref"].(string)
+	sourceURL, _ := item["source_url"].(string)
+	return sourceRef != "" || sourceURL != ""
+}
+
+// hasItemSource returns whether the content of an add item is diffed with a hash rather than stored in state
+func hasItemSource(item map[string]interface{}) bool {
+	return item["source"].(string) != "" || hasRemoteSource(item)
+}
+
+// resolveItemSources fetches the content of add items copied from another ref or repository, returning
+// the items with their content set along with the SHA of the source blobs.
+// The source repository defaults to url and its ref to the default branch.
+func resolveItemSources(ctx context.Context, sources *sourceCache, items []interface{}, url string, auth transport.AuthMethod) ([]interface{}, map[string]interface{}, error) {
+	resolved := make([]interface{}, 0, len(items))
+	blobShas := map[string]interface{}{}
+	commits := map[string]*object.Commit{}
+
+	for _, item := range items {
+		if !hasRemoteSource(item.(map[string]interface{})) {
+			resolved = append(resolved, item)
+			continue
+		}
+
+		path := item.(map[string]interface{})["path"].(string)
+		sourceURL := item.(map[string]interface{})["source_url"].(string)
+		sourceRef := item.(map[string]interface{})["source_ref"].(string)
+		sourcePath := item.(map[string]interface{})["source_path"].(string)
+
+		for _, key := range []string{"content", "content_base64", "source"} {
+			if item.(map[string]interface{})[key].(string) != "" {
+				return nil, nil, fmt.Errorf("failed to open content of file %s: %s cannot be set along with source_ref or source_url", path, key)
+			}
+		}
+
+		if sourceURL == "" {
+			sourceURL = url
+		}
+		if sourceRef == "" {
+			sourceRef = "HEAD"
+		}
+		if sourcePath == "" {
+			sourcePath = path
+		}
+
+		key := fmt.Sprintf("%s#%s", sourceURL, sourceRef)
+		commit, ok := commits[key]
+		if !ok {
+			var err error
+			commit, err = fetchSourceCommit(ctx, sources, sourceURL, sourceRef, auth)
+			if err != nil {
+				return nil, nil, err
+			}
+			commits[key] = commit
+		}
+
+		file, err := commit.File(sourcePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to get file %s at %s of %s: %w", sourcePath, sourceRef, sourceURL, err)
+		}
+		content, err := file.Contents()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read file %s at %s of %s: %w", sourcePath, sourceRef, sourceURL, err)
+		}
+
+		resolvedItem := map[string]interface{}{}
+		for key, value := range item.(map[string]interface{}) {
+			resolvedItem[key] = value
+		}
+		resolvedItem["content_base64"] = base64.StdEncoding.EncodeToString([]byte(content))
+		resolvedItem["source_ref"] = ""
+		resolvedItem["source_url"] = ""
+
+		resolved = append(resolved, resolvedItem)
+		blobShas[path] = file.Hash.String()
+	}
+
+	return resolved, blobShas, nil
+}
+
+// sourceCache keeps the source repositories cloned during a run so that plan and apply clone each of them once.
+// Clones are keyed by the commits of the references they hold, a reference moving in between is cloned again.
+type sourceCache struct {
+	mu     sync.Mutex
+	clones map[string]*sourceClone
+}
+
+// sourceClone is a clone of a source repository, either shallow for a single branch or tag, or complete
+type sourceClone struct {
+	done chan struct{}
+	repo *gogit.Repository
+	err  error
+}
+
+func newSourceCache() *sourceCache {
+	return &sourceCache{
+		clones: map[string]*sourceClone{},
+	}
+}
+
+// clone returns the repository cloned with the options, cloning it when not done yet for the key.
+// Failed clones are not kept so that the next caller tries again.
+func (c *sourceCache) clone(ctx context.Context, key string, options *gogit.CloneOptions) (*gogit.Repository, error) {
+	c.mu.Lock()
+	clone, ok := c.clones[key]
+	if !ok {
+		clone = &sourceClone{done: make(chan struct{})}
+		c.clones[key] = clone
+
+		// The clone is shared, the cancellation of the caller starting it must not abort it for the others
+		go func() {
+			clone.repo, clone.err = gogit.CloneContext(context.Background(), memory.NewStorage(), nil, options)
+			if clone.err != nil {
+				c.mu.Lock()
+				delete(c.clones, key)
+				c.mu.Unlock()
+			}
+			close(clone.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-clone.done:
+		return clone.repo, clone.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// fetchSourceCommit resolves a branch, tag or commit SHA of a repository. Branches and tags are cloned
+// alone without history, commit SHAs need the complete repository.
+func fetchSourceCommit(ctx context.Context, sources *sourceCache, url string, ref string, auth transport.AuthMethod) (*object.Commit, error) {
+	remote := gogit.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: "origin",
+		URLs: []string{url},
+	})
+	refs, err := remote.ListContext(ctx, &gogit.ListOptions{Auth: auth})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references of repository %s: %w", url, err)
+	}
+
+	options := &gogit.CloneOptions{
+		URL:  url,
+		Auth: auth,
+	}
+	names := []plumbing.ReferenceName{plumbing.NewBranchReferenceName(ref), plumbing.NewTagReferenceName(ref)}
+	if ref == "HEAD" {
+		names = []plumbing.ReferenceName{findHeadBranch(refs)}
+	}
+	key := fmt.Sprintf("%s#%s", url, refsDigest(refs))
+	remoteRef := findReference(refs, names)
+	if remoteRef != nil {
+		options.ReferenceName = remoteRef.Name()
+		options.SingleBranch = true
+		options.Depth = 1
+		options.Tags = gogit.NoTags
+		key = fmt.Sprintf("%s#%s#%s", url, remoteRef.Name(), remoteRef.Hash())
+	}
+
+	repo, err := sources.clone(ctx, key, options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone repository %s: %w", url, err)
+	}
+
+	var sha *plumbing.Hash
+	if remoteRef != nil {
+		head, err := repo.Head()
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s of %s: %w", ref, url, err)
+		}
+		hash := head.Hash()
+		sha = &hash
+	} else {
+		sha, err = repo.ResolveRevision(plumbing.Revision(ref))
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s of %s: %w", ref, url, err)
+		}
+	}
+
+	commit, err := repo.CommitObject(*sha)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", sha.String(), err)
+	}
+
+	return commit, nil
+}
+
+// findReference returns the reference with the first of the names found, or nil
+func findReference(refs []*plumbing.Reference, names []plumbing.ReferenceName) *plumbing.Reference {
+	for _, name := range names {
+		for _, ref := range refs {
+			if ref.Name() == name {
+				return ref
+			}
+		}
+	}
+
+	return nil
+}
+
+// refsDigest returns a digest of the references of a repository, which changes whenever one of them moves
+func refsDigest(refs []*plumbing.Reference) string {
+	lines := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		lines = append(lines, ref.String())
+	}
+	sort.Strings(lines)
+
+	return plumbing.ComputeHash(plumbing.BlobObject, []byte(strings.Join(lines, "\n"))).String()
+}
+
+// findHeadBranch returns the name of the default branch HEAD points to, or an empty name when it is detached
+func findHeadBranch(refs []*plumbing.Reference) plumbing.ReferenceName {
+	var head *plumbing.Reference
+	for _, ref := range refs {
+		if ref.Name() == plumbing.HEAD {
+			head = ref
+		}
+	}
+	if head == nil {
+		return ""
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target()
+	}
+
+	// Without the symref capability HEAD is only known by its commit
+	for _, ref := range refs {
+		if ref.Name().IsBranch() && ref.Hash() == head.Hash() {
+			return ref.Name()
+		}
+	}
+
+	return ""
+}
+
+// readSourceBlobs returns the SHA of the source blobs whose copy is unchanged, drifted copies are dropped
+func readSourceBlobs(filesystem billy.Filesystem, items []interface{}, blobShas map[string]interface{}) (map[string]interface{}, error) {
+	writeModes := map[string]string{}
+	for _, item := range items {
+		writeModes[item.(map[string]interface{})["path"].(string)] = getItemWriteMode(item.(map[string]interface{}))
+	}
+
+	remoteShas := map[string]interface{}{}
+	for path, sha := range blobShas {
+		content, _, err := readFile(filesystem, filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		// Seeded and appended files are not expected to match their source
+		if writeModes[path] != "overwrite" || plumbing.ComputeHash(plumbing.BlobObject, content).String() == sha.(string) {
+			remoteShas[path] = sha
+		}
+	}
+
+	return remoteShas, nil
+}
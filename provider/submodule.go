@@ -0,0 +1,401 @@
+package provider
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+
+	gogit "github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// gitmodulesPath is where submodules are declared within a repository
+const gitmodulesPath = ".gitmodules"
+
+// writeSubmodules points gitlink entries of the index to the configured commits and declares their URL in .gitmodules
+func writeSubmodules(repo *gogit.Repository, worktree *gogit.Worktree, submodules []interface{}) error {
+	if len(submodules) == 0 {
+		return nil
+	}
+
+	modules, err := readGitmodules(worktree)
+	if err != nil {
+		return err
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %s", err)
+	}
+
+	for _, submodule := range submodules {
+		subPath := path.Clean(submodule.(map[string]interface{})["path"].(string))
+		sha := plumbing.NewHash(submodule.(map[string]interface{})["sha"].(string))
+		subURL := submodule.(map[string]interface{})["url"].(string)
+
+		module := findGitmodule(modules, subPath)
+		if module == nil {
+			if subURL == "" {
+				return fmt.Errorf("url must be set to add submodule %s", subPath)
+			}
+			module = &config.Submodule{Name: subPath, Path: subPath}
+			modules.Submodules[subPath] = module
+		}
+		if subURL != "" {
+			module.URL = subURL
+		}
+
+		// A gitlink cannot replace tracked files, the tree would hold both
+		for _, other := range idx.Entries {
+			conflict := other.Name == subPath || strings.HasPrefix(other.Name, subPath+"/") || strings.HasPrefix(subPath, other.Name+"/")
+			if other.Mode != filemode.Submodule && conflict {
+				return fmt.Errorf("failed to add submodule %s: it conflicts with tracked file %s", subPath, other.Name)
+			}
+		}
+		err := checkSubmoduleDirectory(worktree, subPath)
+		if err != nil {
+			return err
+		}
+
+		entry, err := idx.Entry(subPath)
+		if err != nil && errors.Is(err, index.ErrEntryNotFound) {
+			entry = idx.Add(subPath)
+		} else if err != nil {
+			return fmt.Errorf("failed to read index entry %s: %s", subPath, err)
+		}
+		entry.Hash = sha
+		entry.Mode = filemode.Submodule
+
+		// The worktree has to hold an empty directory for the submodule to be considered unmodified
+		err = worktree.Filesystem.MkdirAll(subPath, 0755)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %s: %s", subPath, err)
+		}
+	}
+
+	err = writeGitmodules(worktree, modules)
+	if err != nil {
+		return err
+	}
+
+	err = repo.Storer.SetIndex(idx)
+	if err != nil {
+		return fmt.Errorf("failed to write index: %s", err)
+	}
+
+	return nil
+}
+
+// checkSubmoduleDirectory fails when files other than a submodule directory are found at its path
+func checkSubmoduleDirectory(worktree *gogit.Worktree, subPath string) error {
+	info, err := worktree.Filesystem.Lstat(subPath)
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read path %s: %s", subPath, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("failed to add submodule %s: a file exists at its path", subPath)
+	}
+
+	files, err := worktree.Filesystem.ReadDir(subPath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %s", subPath, err)
+	}
+	if len(files) > 0 {
+		return fmt.Errorf("failed to add submodule %s: directory %s is not empty", subPath, subPath)
+	}
+
+	return nil
+}
+
+// isSubmodule returns whether the index holds a gitlink at a path
+func isSubmodule(repo *gogit.Repository, subPath string) (bool, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return false, fmt.Errorf("failed to read index: %s", err)
+	}
+
+	entry, err := idx.Entry(subPath)
+	if err != nil && errors.Is(err, index.ErrEntryNotFound) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read index entry %s: %s", subPath, err)
+	}
+
+	return entry.Mode == filemode.Submodule, nil
+}
+
+// restoreSubmodule points the gitlink of a submodule back to a commit. A zero commit removes the submodule
+// along with its declaration in .gitmodules.
+func restoreSubmodule(repo *gogit.Repository, worktree *gogit.Worktree, subPath string, sha plumbing.Hash) error {
+	if !sha.IsZero() {
+		return writeSubmodules(repo, worktree, []interface{}{
+			map[string]interface{}{"path": subPath, "sha": sha.String(), "url": ""},
+		})
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %s", err)
+	}
+	_, err = idx.Remove(subPath)
+	if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+		return fmt.Errorf("failed to remove index entry %s: %s", subPath, err)
+	}
+	err = repo.Storer.SetIndex(idx)
+	if err != nil {
+		return fmt.Errorf("failed to write index: %s", err)
+	}
+
+	err = worktree.Filesystem.Remove(subPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to delete directory %s: %s", subPath, err)
+	}
+
+	modules, err := readGitmodules(worktree)
+	if err != nil {
+		return err
+	}
+	if module := findGitmodule(modules, subPath); module != nil {
+		delete(modules.Submodules, module.Name)
+	}
+	if len(modules.Submodules) == 0 {
+		_, err = worktree.Remove(gitmodulesPath)
+		if err != nil && !errors.Is(err, index.ErrEntryNotFound) {
+			return fmt.Errorf("failed to delete file %s: %s", gitmodulesPath, err)
+		}
+		return nil
+	}
+
+	return writeGitmodules(worktree, modules)
+}
+
+// readSubmodules returns the submodule items with the commit and URL found in the repository, missing submodules are dropped
+func readSubmodules(repo *gogit.Repository, worktree *gogit.Worktree, submodules []interface{}) ([]interface{}, error) {
+	remoteSubmodules := make([]interface{}, 0, len(submodules))
+	if len(submodules) == 0 {
+		return remoteSubmodules, nil
+	}
+
+	modules, err := readGitmodules(worktree)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %s", err)
+	}
+
+	for _, submodule := range submodules {
+		subPath := path.Clean(submodule.(map[string]interface{})["path"].(string))
+
+		entry, err := idx.Entry(subPath)
+		if err != nil && errors.Is(err, index.ErrEntryNotFound) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read index entry %s: %s", subPath, err)
+		}
+		if entry.Mode != filemode.Submodule {
+			continue
+		}
+
+		remoteSubmodule := map[string]interface{}{}
+		for key, value := range submodule.(map[string]interface{}) {
+			remoteSubmodule[key] = value
+		}
+		remoteSubmodule["sha"] = entry.Hash.String()
+
+		// The URL is only diffed when configured
+		if module := findGitmodule(modules, subPath); remoteSubmodule["url"].(string) != "" && module != nil {
+			remoteSubmodule["url"] = module.URL
+		}
+
+		remoteSubmodules = append(remoteSubmodules, remoteSubmodule)
+	}
+
+	return remoteSubmodules, nil
+}
+
+func readGitmodules(worktree *gogit.Worktree) (*config.Modules, error) {
+	modules := config.NewModules()
+
+	content, _, err := readFile(worktree.Filesystem, gitmodulesPath)
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return modules, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	err = modules.Unmarshal(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", gitmodulesPath, err)
+	}
+
+	return modules, nil
+}
+
+func writeGitmodules(worktree *gogit.Worktree, modules *config.Modules) error {
+	content, err := modules.Marshal()
+	if err != nil {
+		return fmt.Errorf("failed to encode %s: %s", gitmodulesPath, err)
+	}
+
+	return writeFile(worktree.Filesystem, gitmodulesPath, bytes.NewReader(content), 0644)
+}
+
+// findGitmodule returns the submodule declared at a path, or nil when not declared
+func findGitmodule(modules *config.Modules, subPath string) *config.Submodule {
+	for _, module := range modules.Submodules {
+		if path.Clean(module.Path) == subPath {
+			return module
+		}
+	}
+
+	return nil
+}
+
+// readSubmoduleFile reads a file within a submodule of the commit checked out, recursing into nested submodules.
+// Submodules are cloned from the URL in .gitmodules, resolved against repoURL when relative.
+func readSubmoduleFile(ctx context.Context, sources *sourceCache, repo *gogit.Repository, repoURL string, filePath string, auth transport.AuthMethod) ([]byte, filemode.FileMode, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to get commit %s: %w", head.Hash().String(), err)
+	}
+
+	return readCommitSubmoduleFile(ctx, sources, commit, repoURL, filePath, auth)
+}
+
+// readCommitSubmoduleFile reads a file within a submodule of a commit, recursing into nested submodules
+func readCommitSubmoduleFile(ctx context.Context, sources *sourceCache, commit *object.Commit, repoURL string, filePath string, auth transport.AuthMethod) ([]byte, filemode.FileMode, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to get tree of commit %s: %w", commit.Hash.String(), err)
+	}
+
+	// Look for the gitlink the file is below
+	subPath, sha := findGitlink(tree, filePath)
+	if subPath == "" {
+		return nil, filemode.Empty, fs.ErrNotExist
+	}
+
+	modules, err := readTreeGitmodules(tree)
+	if err != nil {
+		return nil, filemode.Empty, err
+	}
+	module := findGitmodule(modules, subPath)
+	if module == nil {
+		return nil, filemode.Empty, fmt.Errorf("submodule %s is not declared in %s", subPath, gitmodulesPath)
+	}
+	subURL, err := resolveSubmoduleURL(repoURL, module.URL)
+	if err != nil {
+		return nil, filemode.Empty, err
+	}
+
+	// The pinned commit may only be reachable from a tag, the clone is shared by the reads of the run
+	subRepo, err := sources.clone(ctx, fmt.Sprintf("%s#%s", subURL, sha.String()), &gogit.CloneOptions{
+		URL:  subURL,
+		Auth: auth,
+		Tags: gogit.AllTags,
+	})
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to clone submodule %s: %w", subPath, err)
+	}
+	subCommit, err := subRepo.CommitObject(sha)
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to get commit %s of submodule %s, it has to be reachable from a branch or tag: %w", sha.String(), subPath, err)
+	}
+	subTree, err := subCommit.Tree()
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to get tree of commit %s of submodule %s: %w", sha.String(), subPath, err)
+	}
+
+	subFilePath := strings.TrimPrefix(filePath, subPath+"/")
+	file, err := subTree.File(subFilePath)
+	if err != nil && errors.Is(err, object.ErrFileNotFound) {
+		return readCommitSubmoduleFile(ctx, sources, subCommit, subURL, subFilePath, auth)
+	} else if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to get file %s of submodule %s: %w", subFilePath, subPath, err)
+	}
+
+	// The content of a symlink is its target, like files read from a worktree
+	content, err := file.Contents()
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to read file %s of submodule %s: %w", subFilePath, subPath, err)
+	}
+
+	return []byte(content), file.Mode, nil
+}
+
+// readTreeGitmodules returns the submodules declared in the .gitmodules file of a tree
+func readTreeGitmodules(tree *object.Tree) (*config.Modules, error) {
+	modules := config.NewModules()
+
+	file, err := tree.File(gitmodulesPath)
+	if err != nil && errors.Is(err, object.ErrFileNotFound) {
+		return modules, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get file %s: %w", gitmodulesPath, err)
+	}
+	content, err := file.Contents()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file %s: %w", gitmodulesPath, err)
+	}
+
+	err = modules.Unmarshal([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", gitmodulesPath, err)
+	}
+
+	return modules, nil
+}
+
+// findGitlink returns the path and commit of the gitlink a file is below, or an empty path when there is none
+func findGitlink(tree *object.Tree, filePath string) (string, plumbing.Hash) {
+	segments := strings.Split(path.Clean(filePath), "/")
+
+	for i := 1; i < len(segments); i++ {
+		prefix := strings.Join(segments[:i], "/")
+
+		entry, err := tree.FindEntry(prefix)
+		if err != nil {
+			return "", plumbing.ZeroHash
+		}
+		if entry.Mode == filemode.Submodule {
+			return prefix, entry.Hash
+		}
+	}
+
+	return "", plumbing.ZeroHash
+}
+
+// resolveSubmoduleURL resolves a submodule URL relative to the URL of its superproject, like Git does
+func resolveSubmoduleURL(repoURL string, subURL string) (string, error) {
+	if !strings.HasPrefix(subURL, "./") && !strings.HasPrefix(subURL, "../") {
+		return subURL, nil
+	}
+
+	base, err := url.Parse(strings.TrimSuffix(repoURL, "/"))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse URL %s: %w", repoURL, err)
+	}
+
+	// The superproject URL is the directory relative URLs start from
+	base.Path = path.Join(base.Path, subURL)
+	return base.String(), nil
+}
@@ -1,8 +1,22 @@
 package provider
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
@@ -87,6 +101,89 @@ func authSchema() *schema.Schema {
 	}
 }
 
+func changesSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:     schema.TypeList,
+		Computed: true,
+		Elem: &schema.Resource{
+			Schema: map[string]*schema.Schema{
+				"action": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"path": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"previous_path": {
+					Type:     schema.TypeString,
+					Computed: true,
+				},
+				"additions": {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+				"deletions": {
+					Type:     schema.TypeInt,
+					Computed: true,
+				},
+			},
+		},
+	}
+}
+
+// validateRepositoryPath ensures a path is relative to the repository root and does not escape it
+func validateRepositoryPath(value interface{}, key string) ([]string, []error) {
+	name, ok := value.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", key)}
+	}
+
+	if name == "" {
+		return nil, []error{fmt.Errorf("expected %s to not be empty", key)}
+	}
+	if strings.HasPrefix(name, "/") {
+		return nil, []error{fmt.Errorf("expected %s to be relative to the repository root, got %s", key, name)}
+	}
+	for _, segment := range strings.Split(name, "/") {
+		if segment == ".." || segment == ".git" {
+			return nil, []error{fmt.Errorf("expected %s to stay within the repository, got %s", key, name)}
+		}
+	}
+
+	return nil, nil
+}
+
+func validateDuration(value interface{}, key string) ([]string, []error) {
+	duration, ok := value.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", key)}
+	}
+
+	parsed, err := time.ParseDuration(duration)
+	if err != nil {
+		return nil, []error{fmt.Errorf("expected %s to be a duration such as 30s or 5m, got %s", key, duration)}
+	}
+	if parsed < 0 {
+		return nil, []error{fmt.Errorf("expected %s to not be negative, got %s", key, duration)}
+	}
+
+	return nil, nil
+}
+
+// isConfigKnown returns whether an attribute of the configuration is wholly known
+func isConfigKnown(d *schema.ResourceDiff, key string) bool {
+	config := d.GetRawConfig()
+	if !config.IsKnown() {
+		return false
+	}
+	if config.IsNull() {
+		return true
+	}
+
+	return config.GetAttr(key).IsWhollyKnown()
+}
+
 func getMapItem(value interface{}) map[string]interface{} {
 	if value == nil {
 		return nil
@@ -101,7 +198,14 @@ func getMapItem(value interface{}) map[string]interface{} {
 	return data.(map[string]interface{})
 }
 
-func getAuth(d *schema.ResourceData) (transport.AuthMethod, error) {
+// resourceGetter is implemented by both schema.ResourceData and schema.ResourceDiff
+type resourceGetter interface {
+	Get(key string) interface{}
+	GetChange(key string) (interface{}, interface{})
+	HasChange(key string) bool
+}
+
+func getAuth(d resourceGetter) (transport.AuthMethod, error) {
 	authData := getMapItem(d.Get("auth"))
 	if authData == nil {
 		return nil, nil
@@ -163,3 +267,456 @@ func getAuth(d *schema.ResourceData) (transport.AuthMethod, error) {
 
 	return nil, errors.New("unknown auth method")
 }
+
+// getReadAuth returns the credentials of the auth block, or those of imports when the block is not set,
+// as the state read right after an import has no auth block yet
+func getReadAuth(d resourceGetter) (transport.AuthMethod, error) {
+	auth, err := getAuth(d)
+	if err != nil || auth != nil {
+		return auth, err
+	}
+
+	return getImportAuth()
+}
+
+// getImportAuth returns the credentials set in the environment for imports, as the auth block is not available then.
+// Without any, the SSH agent is used for SSH URLs.
+func getImportAuth() (transport.AuthMethod, error) {
+	if keyPath := os.Getenv("GIT_IMPORT_SSH_KEY_PATH"); keyPath != "" {
+		username := os.Getenv("GIT_IMPORT_USERNAME")
+		if username == "" {
+			username = "git"
+		}
+
+		publicKeys, err := ssh.NewPublicKeysFromFile(username, keyPath, os.Getenv("GIT_IMPORT_PASSWORD"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read SSH key %s: %s", keyPath, err)
+		}
+		return publicKeys, nil
+	}
+
+	if token := os.Getenv("GIT_IMPORT_TOKEN"); token != "" {
+		return &http.TokenAuth{
+			Token: token,
+		}, nil
+	}
+
+	if username := os.Getenv("GIT_IMPORT_USERNAME"); username != "" {
+		return &http.BasicAuth{
+			Username: username,
+			Password: os.Getenv("GIT_IMPORT_PASSWORD"),
+		}, nil
+	}
+
+	return nil, nil
+}
+
+func openItemContent(item map[string]interface{}) (io.ReadCloser, error) {
+	content := item["content"].(string)
+	contentBase64 := item["content_base64"].(string)
+	source := item["source"].(string)
+
+	count := 0
+	for _, value := range []string{content, contentBase64, source} {
+		if value != "" {
+			count++
+		}
+	}
+	if count > 1 {
+		return nil, errors.New("only one of content, content_base64 or source can be set")
+	}
+
+	if source != "" {
+		return os.Open(source)
+	}
+
+	if contentBase64 != "" {
+		return io.NopCloser(base64.NewDecoder(base64.StdEncoding, strings.NewReader(contentBase64))), nil
+	}
+
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+// getItemMode returns the Git file mode of an add item, a regular file by default
+func getItemMode(item map[string]interface{}) filemode.FileMode {
+	mode, err := filemode.New(item["mode"].(string))
+	if err != nil || mode == filemode.Empty {
+		return filemode.Regular
+	}
+
+	return mode
+}
+
+// getItemWriteMode returns how an add item is written, overwriting the file by default
+func getItemWriteMode(item map[string]interface{}) string {
+	writeMode, _ := item["write_mode"].(string)
+	if writeMode == "" {
+		return "overwrite"
+	}
+
+	return writeMode
+}
+
+// missingLines returns the non-empty lines of content which are not lines of the file yet
+func missingLines(file []byte, content []byte) []string {
+	present := map[string]bool{}
+	for _, line := range strings.Split(string(file), "\n") {
+		present[strings.TrimSuffix(line, "\r")] = true
+	}
+
+	var missing []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" || present[line] {
+			continue
+		}
+
+		present[line] = true
+		missing = append(missing, line)
+	}
+
+	return missing
+}
+
+// removeLines returns content without the given lines
+func removeLines(content string, lines []string) string {
+	removed := map[string]bool{}
+	for _, line := range lines {
+		removed[line] = true
+	}
+
+	var kept []string
+	for _, line := range strings.Split(content, "\n") {
+		if !removed[strings.TrimSuffix(line, "\r")] {
+			kept = append(kept, line)
+		}
+	}
+
+	return strings.Join(kept, "\n")
+}
+
+// appendLines appends the lines of content missing from a file, creating the file when needed.
+// The mode of an existing file is kept when mode is empty.
+func appendLines(fs billy.Filesystem, path string, content io.Reader, mode filemode.FileMode) error {
+	lines, err := io.ReadAll(content)
+	if err != nil {
+		return fmt.Errorf("failed to read content of file %s: %s", path, err)
+	}
+
+	existing, existingMode, err := readFile(fs, path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if existingMode == filemode.Symlink {
+		return fmt.Errorf("failed to append lines to file %s: file is a symlink", path)
+	}
+	if mode == filemode.Empty {
+		mode = existingMode
+	}
+
+	var buffer bytes.Buffer
+	buffer.Write(existing)
+
+	missing := missingLines(existing, lines)
+	if len(missing) > 0 && len(existing) > 0 && !bytes.HasSuffix(existing, []byte("\n")) {
+		buffer.WriteString("\n")
+	}
+	for _, line := range missing {
+		buffer.WriteString(line + "\n")
+	}
+
+	if mode == filemode.Executable {
+		return writeFile(fs, path, &buffer, 0755)
+	}
+	return writeFile(fs, path, &buffer, 0644)
+}
+
+// editFile rewrites a text file with the result of edit, keeping its mode.
+// Missing files are edited as empty files.
+func editFile(filesystem billy.Filesystem, path string, edit func(string) (string, error)) error {
+	file, mode, err := readFile(filesystem, path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if mode == filemode.Symlink {
+		return fmt.Errorf("failed to edit file %s: file is a symlink", path)
+	}
+
+	edited, err := edit(string(file))
+	if err != nil {
+		return fmt.Errorf("failed to edit file %s: %s", path, err)
+	}
+
+	if mode == filemode.Executable {
+		return writeFile(filesystem, path, strings.NewReader(edited), 0755)
+	}
+	return writeFile(filesystem, path, strings.NewReader(edited), 0644)
+}
+
+func hashFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func removeExisting(fs billy.Filesystem, path string) error {
+	_, err := fs.Lstat(path)
+	if err != nil && errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	return fs.Remove(path)
+}
+
+func writeFile(fs billy.Filesystem, path string, content io.Reader, perm os.FileMode) error {
+	// Remove existing file so that its mode is replaced too
+	err := removeExisting(fs, path)
+	if err != nil {
+		return fmt.Errorf("failed to replace file %s: %s", path, err)
+	}
+
+	// Create, write then close file
+	file, err := fs.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %s", path, err)
+	}
+
+	_, err = io.Copy(file, content)
+	if err != nil {
+		return fmt.Errorf("failed to write to file %s: %s", path, err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close file %s: %s", path, err)
+	}
+
+	return nil
+}
+
+func writeSymlink(fs billy.Filesystem, path string, target string) error {
+	err := removeExisting(fs, path)
+	if err != nil {
+		return fmt.Errorf("failed to replace file %s: %s", path, err)
+	}
+
+	err = fs.Symlink(target, path)
+	if err != nil {
+		return fmt.Errorf("failed to create symlink %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// readFile reads the content and the Git mode of a file, the content of a symlink being its target
+func readFile(fs billy.Filesystem, path string) ([]byte, filemode.FileMode, error) {
+	info, err := fs.Lstat(path)
+	if err != nil {
+		return nil, filemode.Empty, err
+	}
+
+	mode, err := filemode.NewFromOSFileMode(info.Mode())
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to get mode of file %s: %w", path, err)
+	}
+
+	if mode == filemode.Symlink {
+		target, err := fs.Readlink(path)
+		if err != nil {
+			return nil, filemode.Empty, fmt.Errorf("failed to read symlink %s: %w", path, err)
+		}
+
+		return []byte(target), mode, nil
+	}
+
+	// Open, read then close file
+	file, err := fs.Open(path)
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to open file %s: %w", path, err)
+	}
+
+	content, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, filemode.Empty, fmt.Errorf("failed to read file %s: %w", path, err)
+	}
+
+	err = file.Close()
+	if err != nil {
+		return nil, filemode.Empty, fmt.Errorf("failed to close file %s: %w", path, err)
+	}
+
+	return content, mode, nil
+}
+
+// copyFile copies a file or a symlink within a filesystem, preserving its mode
+func copyFile(fs billy.Filesystem, from string, to string) error {
+	info, err := fs.Lstat(from)
+	if err != nil {
+		return fmt.Errorf("failed to stat file %s: %s", from, err)
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := fs.Readlink(from)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink %s: %s", from, err)
+		}
+
+		return writeSymlink(fs, to, target)
+	}
+
+	file, err := fs.Open(from)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %s", from, err)
+	}
+	defer file.Close()
+
+	return writeFile(fs, to, file, info.Mode().Perm())
+}
+
+type directoryFile struct {
+	source     string
+	path       string
+	executable bool
+}
+
+func listDirectoryFiles(directory map[string]interface{}) ([]directoryFile, error) {
+	source := directory["source"].(string)
+	destination := directory["destination"].(string)
+
+	var files []directoryFile
+	err := filepath.WalkDir(source, func(filename string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(source, filename)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+
+		if !matchDirectoryFilters(directory, name) {
+			return nil
+		}
+
+		files = append(files, directoryFile{
+			source:     filename,
+			path:       path.Join(destination, name),
+			executable: info.Mode()&0111 != 0,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return files, nil
+}
+
+func matchDirectoryFilters(directory map[string]interface{}, name string) bool {
+	includes := directory["include"].([]interface{})
+	excludes := directory["exclude"].([]interface{})
+
+	// Filter files with include then exclude patterns
+	if len(includes) > 0 && !matchAnyGlob(includes, name) {
+		return false
+	}
+
+	return !matchAnyGlob(excludes, name)
+}
+
+func listFiles(filesystem billy.Filesystem, dir string) ([]string, error) {
+	entries, err := filesystem.ReadDir(filesystem.Join(dir))
+	if err != nil && errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if !entry.IsDir() {
+			files = append(files, name)
+			continue
+		}
+
+		children, err := listFiles(filesystem, name)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, children...)
+	}
+
+	return files, nil
+}
+
+func matchAnyGlob(patterns []interface{}, name string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern.(string), name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchGlob matches a slash separated name against a glob pattern where "**"
+// matches any number of directories. Patterns without a slash match the base name.
+func matchGlob(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		matched, _ := path.Match(pattern, path.Base(name))
+		return matched
+	}
+
+	return matchPathGlob(pattern, name)
+}
+
+// matchPathGlob matches a slash separated name against a glob pattern anchored
+// at the repository root, where "**" matches any number of directories
+func matchPathGlob(pattern, name string) bool {
+	return matchGlobSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobSegments(patterns, names []string) bool {
+	if len(patterns) == 0 {
+		return len(names) == 0
+	}
+
+	if patterns[0] == "**" {
+		for i := 0; i <= len(names); i++ {
+			if matchGlobSegments(patterns[1:], names[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(names) == 0 {
+		return false
+	}
+
+	matched, _ := path.Match(patterns[0], names[0])
+	return matched && matchGlobSegments(patterns[1:], names[1:])
+}
@@ -0,0 +1,1273 @@
+package provider
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-git/go-billy/v5"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"gopkg.in/yaml.v3"
+)
+
+// writeValues sets the value of keys within JSON, YAML or TOML files
+func writeValues(filesystem billy.Filesystem, values []interface{}) error {
+	for _, value := range values {
+		path := value.(map[string]interface{})["path"].(string)
+		format := value.(map[string]interface{})["format"].(string)
+		keys := strings.Split(value.(map[string]interface{})["key"].(string), ".")
+
+		// Numbers are kept as written rather than rounded to a float
+		var decoded interface{}
+		decoder := json.NewDecoder(strings.NewReader(value.(map[string]interface{})["value"].(string)))
+		decoder.UseNumber()
+		err := decoder.Decode(&decoded)
+		if err != nil {
+			return fmt.Errorf("failed to decode value of key %s: %s", value.(map[string]interface{})["key"].(string), err)
+		}
+
+		err = editFile(filesystem, filesystem.Join(path), func(file string) (string, error) {
+			switch format {
+			case "json":
+				return setJSONValue(file, keys, value.(map[string]interface{})["value"].(string))
+			case "yaml":
+				return setYAMLValue(file, keys, decoded)
+			default:
+				return setTOMLValue(file, keys, decoded)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readValues returns the items with their value read from the files, keys missing from their file are dropped
+func readValues(filesystem billy.Filesystem, values []interface{}) ([]interface{}, error) {
+	remoteValues := make([]interface{}, 0, len(values))
+
+	for _, value := range values {
+		path := value.(map[string]interface{})["path"].(string)
+		format := value.(map[string]interface{})["format"].(string)
+		keys := strings.Split(value.(map[string]interface{})["key"].(string), ".")
+
+		file, _, err := readFile(filesystem, filesystem.Join(path))
+		if err != nil && errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		var remoteValue string
+		var ok bool
+		switch format {
+		case "json":
+			remoteValue, ok, err = getJSONValue(string(file), keys)
+		case "yaml":
+			remoteValue, ok, err = getYAMLValue(string(file), keys)
+		default:
+			remoteValue, ok, err = getTOMLValue(string(file), keys)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key %s of file %s: %s", value.(map[string]interface{})["key"].(string), path, err)
+		}
+		if !ok {
+			continue
+		}
+
+		remoteItem := map[string]interface{}{}
+		for key, value := range value.(map[string]interface{}) {
+			remoteItem[key] = value
+		}
+
+		// Keep the configured encoding of equal values
+		if !jsonEqual(remoteValue, remoteItem["value"].(string)) {
+			remoteItem["value"] = remoteValue
+		}
+
+		remoteValues = append(remoteValues, remoteItem)
+	}
+
+	return remoteValues, nil
+}
+
+// jsonEqual returns whether two JSON documents hold the same value
+func jsonEqual(a string, b string) bool {
+	decodedA, errA := decodeJSONNumbers(a)
+	decodedB, errB := decodeJSONNumbers(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+
+	return reflect.DeepEqual(decodedA, decodedB)
+}
+
+// decodeJSONNumbers decodes a JSON document with its integers kept exact, whatever their size
+func decodeJSONNumbers(content string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+
+	var value interface{}
+	err := decoder.Decode(&value)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeJSONNumbers(value), nil
+}
+
+// canonicalNumber is the decimal form of a JSON number, told apart from strings when compared
+type canonicalNumber string
+
+// normalizeJSONNumbers replaces numbers by their canonical decimal form
+func normalizeJSONNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if integer, ok := new(big.Int).SetString(value.String(), 10); ok {
+			return canonicalNumber(integer.String())
+		}
+		float, _ := value.Float64()
+		return canonicalNumber(strconv.FormatFloat(float, 'g', -1, 64))
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = normalizeJSONNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = normalizeJSONNumbers(item)
+		}
+	}
+
+	return value
+}
+
+// suppressEquivalentJSON ignores differences of JSON encoding
+func suppressEquivalentJSON(k, old, new string, d *schema.ResourceData) bool {
+	return jsonEqual(old, new)
+}
+
+// detectIndent returns the indentation of the first indented line, or two spaces
+func detectIndent(file string) string {
+	for _, line := range strings.Split(file, "\n") {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)]
+		}
+	}
+
+	return "  "
+}
+
+// jsonObject is a JSON object keeping the order of its keys
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func decodeOrderedJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch token {
+	case json.Delim('{'):
+		object := &jsonObject{values: map[string]interface{}{}}
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+
+			if _, ok := object.values[key.(string)]; !ok {
+				object.keys = append(object.keys, key.(string))
+			}
+			object.values[key.(string)] = value
+		}
+
+		_, err = decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+
+		_, err = decoder.Token()
+		return array, err
+	}
+
+	return token, nil
+}
+
+func encodeOrderedJSON(buffer *bytes.Buffer, value interface{}) error {
+	switch value := value.(type) {
+	case *jsonObject:
+		buffer.WriteString("{")
+		for i, key := range value.keys {
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			err := encodeOrderedJSON(buffer, key)
+			if err != nil {
+				return err
+			}
+			buffer.WriteString(":")
+			err = encodeOrderedJSON(buffer, value.values[key])
+			if err != nil {
+				return err
+			}
+		}
+		buffer.WriteString("}")
+	case []interface{}:
+		buffer.WriteString("[")
+		for i, item := range value {
+			if i > 0 {
+				buffer.WriteString(",")
+			}
+			err := encodeOrderedJSON(buffer, item)
+			if err != nil {
+				return err
+			}
+		}
+		buffer.WriteString("]")
+	default:
+		encoder := json.NewEncoder(buffer)
+		encoder.SetEscapeHTML(false)
+		err := encoder.Encode(value)
+		if err != nil {
+			return err
+		}
+		buffer.Truncate(buffer.Len() - 1)
+	}
+
+	return nil
+}
+
+func parseOrderedJSON(content string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+
+	value, err := decodeOrderedJSON(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("unexpected content after the JSON document")
+	}
+
+	return value, nil
+}
+
+// setJSONValue sets the value of a key, keeping the order of the other keys
+func setJSONValue(file string, keys []string, value string) (string, error) {
+	newValue, err := parseOrderedJSON(value)
+	if err != nil {
+		return "", err
+	}
+
+	var root interface{}
+	if strings.TrimSpace(file) != "" {
+		root, err = parseOrderedJSON(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse JSON: %s", err)
+		}
+	}
+
+	root, err = setJSONKey(root, keys, newValue)
+	if err != nil {
+		return "", err
+	}
+
+	var compact bytes.Buffer
+	err = encodeOrderedJSON(&compact, root)
+	if err != nil {
+		return "", err
+	}
+
+	var indented bytes.Buffer
+	err = json.Indent(&indented, compact.Bytes(), "", detectIndent(file))
+	if err != nil {
+		return "", err
+	}
+
+	return indented.String() + "\n", nil
+}
+
+func setJSONKey(node interface{}, keys []string, value interface{}) (interface{}, error) {
+	if len(keys) == 0 {
+		return value, nil
+	}
+
+	switch current := node.(type) {
+	case nil:
+		// Missing keys are created as objects
+		child, err := setJSONKey(nil, keys[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return &jsonObject{keys: []string{keys[0]}, values: map[string]interface{}{keys[0]: child}}, nil
+	case *jsonObject:
+		child, err := setJSONKey(current.values[keys[0]], keys[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := current.values[keys[0]]; !ok {
+			current.keys = append(current.keys, keys[0])
+		}
+		current.values[keys[0]] = child
+		return current, nil
+	case []interface{}:
+		index, err := strconv.Atoi(keys[0])
+		if err != nil || index < 0 || index >= len(current) {
+			return nil, fmt.Errorf("index %s is out of range of an array of %d items", keys[0], len(current))
+		}
+		current[index], err = setJSONKey(current[index], keys[1:], value)
+		return current, err
+	}
+
+	return nil, fmt.Errorf("key %s is not within an object or an array", keys[0])
+}
+
+// getJSONValue returns the compact JSON value of a key, and whether it exists
+func getJSONValue(file string, keys []string) (string, bool, error) {
+	node, err := parseOrderedJSON(file)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse JSON: %s", err)
+	}
+
+	for _, key := range keys {
+		switch current := node.(type) {
+		case *jsonObject:
+			child, ok := current.values[key]
+			if !ok {
+				return "", false, nil
+			}
+			node = child
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(current) {
+				return "", false, nil
+			}
+			node = current[index]
+		default:
+			return "", false, nil
+		}
+	}
+
+	var buffer bytes.Buffer
+	err = encodeOrderedJSON(&buffer, node)
+	if err != nil {
+		return "", false, err
+	}
+
+	return buffer.String(), true, nil
+}
+
+// setYAMLValue sets the value of a key within the first document, keeping comments, the order of keys and quoting.
+// The file is edited in place whenever possible, so that blank lines and the other documents are kept as written.
+func setYAMLValue(file string, keys []string, value interface{}) (string, error) {
+	documents, err := decodeYAMLDocuments(file)
+	if err != nil {
+		return "", err
+	}
+
+	// Files without a document, possibly holding comments, are prepended to a new one
+	if len(documents) == 0 {
+		document := &yaml.Node{
+			Kind:    yaml.DocumentNode,
+			Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}},
+		}
+		prefix := ""
+		if strings.TrimSpace(file) != "" {
+			prefix = strings.TrimRight(file, "\n") + "\n"
+		}
+
+		err = setYAMLNode(document, keys, value)
+		if err != nil {
+			return "", err
+		}
+		encoded, err := encodeYAMLDocuments([]*yaml.Node{document}, detectIndent(file))
+		if err != nil {
+			return "", err
+		}
+		return prefix + encoded, nil
+	}
+
+	// The scalar replaced is located in the file before the documents are edited
+	node, flow, err := findYAMLNode(documents[0], keys)
+	if err != nil {
+		return "", err
+	}
+	var original *yaml.Node
+	if node != nil && node.Kind == yaml.ScalarNode {
+		copied := *node
+		original = &copied
+	}
+
+	err = setYAMLNode(documents[0], keys, value)
+	if err != nil {
+		return "", err
+	}
+	expected, err := decodeYAMLNodes(documents)
+	if err != nil {
+		return "", err
+	}
+
+	// Only the value is replaced when it is a scalar on a single line, otherwise the first document is encoded again
+	edited, ok := spliceYAMLScalar(file, original, node, flow)
+	if !ok {
+		first, err := encodeYAMLDocuments(documents[:1], detectIndent(file))
+		if err != nil {
+			return "", err
+		}
+		edited = first + yamlDocumentsAfter(file, documents)
+	}
+
+	// Edits in place are checked against the documents, they are all encoded again when not equivalent
+	if decoded, err := decodeYAMLValues(edited); err == nil && reflect.DeepEqual(decoded, expected) {
+		return edited, nil
+	}
+
+	return encodeYAMLDocuments(documents, detectIndent(file))
+}
+
+// decodeYAMLDocuments parses every document of a YAML file
+func decodeYAMLDocuments(file string) ([]*yaml.Node, error) {
+	var documents []*yaml.Node
+
+	decoder := yaml.NewDecoder(strings.NewReader(file))
+	for {
+		document := &yaml.Node{}
+		err := decoder.Decode(document)
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse YAML: %s", err)
+		}
+
+		documents = append(documents, document)
+	}
+}
+
+// decodeYAMLNodes decodes the value of each document
+func decodeYAMLNodes(documents []*yaml.Node) ([]interface{}, error) {
+	values := make([]interface{}, 0, len(documents))
+	for _, document := range documents {
+		var value interface{}
+		err := document.Decode(&value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode YAML: %s", err)
+		}
+		values = append(values, value)
+	}
+
+	return values, nil
+}
+
+// decodeYAMLValues decodes the value of each document of a YAML file
+func decodeYAMLValues(file string) ([]interface{}, error) {
+	documents, err := decodeYAMLDocuments(file)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeYAMLNodes(documents)
+}
+
+func encodeYAMLDocuments(documents []*yaml.Node, indent string) (string, error) {
+	var buffer bytes.Buffer
+	encoder := yaml.NewEncoder(&buffer)
+	encoder.SetIndent(len(indent))
+
+	for _, document := range documents {
+		err := encoder.Encode(document)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode YAML: %s", err)
+		}
+	}
+	err := encoder.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to encode YAML: %s", err)
+	}
+
+	return buffer.String(), nil
+}
+
+// yamlDocumentsAfter returns the text of the documents following the first one, starting with their separator
+func yamlDocumentsAfter(file string, documents []*yaml.Node) string {
+	if len(documents) < 2 {
+		return ""
+	}
+
+	lines := strings.SplitAfter(file, "\n")
+	rest := strings.Join(lines[documents[1].Line-1:], "")
+	if !strings.HasPrefix(rest, "---") {
+		rest = "---\n" + rest
+	}
+
+	return rest
+}
+
+// findYAMLNode returns the node of a key, following aliases, or nil when a key is missing.
+// Whether the node is within a flow mapping or sequence is returned too.
+func findYAMLNode(document *yaml.Node, keys []string) (*yaml.Node, bool, error) {
+	node := document.Content[0]
+	flow := false
+	for _, key := range keys {
+		for node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+		flow = flow || node.Style&yaml.FlowStyle != 0
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			var child *yaml.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					child = node.Content[i+1]
+				}
+			}
+			if child == nil {
+				return nil, flow, nil
+			}
+			node = child
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				return nil, flow, fmt.Errorf("index %s is out of range of a sequence of %d items", key, len(node.Content))
+			}
+			node = node.Content[index]
+		default:
+			return nil, flow, fmt.Errorf("key %s is not within a mapping or a sequence", key)
+		}
+	}
+
+	return node, flow, nil
+}
+
+// setYAMLNode sets the value of a key within a document, creating missing keys as mappings
+func setYAMLNode(document *yaml.Node, keys []string, value interface{}) error {
+	node := document.Content[0]
+	for _, key := range keys {
+		// Keys below an alias are set on its anchor, like they are read
+		for node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			var child *yaml.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					child = node.Content[i+1]
+				}
+			}
+
+			// Missing keys are created as mappings
+			if child == nil {
+				child = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+			}
+			node = child
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				return fmt.Errorf("index %s is out of range of a sequence of %d items", key, len(node.Content))
+			}
+			node = node.Content[index]
+		default:
+			return fmt.Errorf("key %s is not within a mapping or a sequence", key)
+		}
+	}
+
+	var newNode yaml.Node
+	err := newNode.Encode(yamlNumbers(value))
+	if err != nil {
+		return fmt.Errorf("failed to encode value: %s", err)
+	}
+
+	// Replace the value only, comments of the key stay in place
+	if node.Kind != yaml.ScalarNode || newNode.Kind != yaml.ScalarNode || newNode.Tag != "!!str" ||
+		(node.Style != yaml.DoubleQuotedStyle && node.Style != yaml.SingleQuotedStyle) {
+		node.Style = newNode.Style
+	}
+	node.Kind = newNode.Kind
+	node.Tag = newNode.Tag
+	node.Value = newNode.Value
+	node.Content = newNode.Content
+	node.Alias = nil
+
+	return nil
+}
+
+// spliceYAMLScalar replaces the text of a scalar written on a single line by the encoding of the node set in its place
+func spliceYAMLScalar(file string, original *yaml.Node, node *yaml.Node, flow bool) (string, bool) {
+	if original == nil || original.Anchor != "" || node.Kind != yaml.ScalarNode || original.Line < 1 || original.Column < 1 {
+		return "", false
+	}
+
+	scalar := *node
+	scalar.HeadComment, scalar.LineComment, scalar.FootComment = "", "", ""
+	encoded, err := yaml.Marshal(&scalar)
+	if err != nil {
+		return "", false
+	}
+	value := strings.TrimSuffix(string(encoded), "\n")
+	if strings.Contains(value, "\n") {
+		return "", false
+	}
+
+	lines := strings.SplitAfter(file, "\n")
+	if original.Line > len(lines) {
+		return "", false
+	}
+	lineStart := len(strings.Join(lines[:original.Line-1], ""))
+	line := strings.TrimRight(lines[original.Line-1], "\r\n")
+
+	// Columns count characters, not bytes
+	runes := []rune(line)
+	if original.Column-1 > len(runes) {
+		return "", false
+	}
+	start := len(string(runes[:original.Column-1]))
+
+	end, ok := yamlScalarEnd(line, start, original.Style, flow)
+	if !ok {
+		return "", false
+	}
+
+	return file[:lineStart+start] + value + file[lineStart+end:], true
+}
+
+// yamlScalarEnd returns the offset a plain or quoted scalar starting at an offset ends at, and whether it ends on the line
+func yamlScalarEnd(line string, start int, style yaml.Style, flow bool) (int, bool) {
+	switch style {
+	case yaml.DoubleQuotedStyle:
+		for end := start + 1; end < len(line); end++ {
+			if line[end] == '\\' {
+				end++
+				continue
+			}
+			if line[end] == '"' {
+				return end + 1, true
+			}
+		}
+		return 0, false
+	case yaml.SingleQuotedStyle:
+		for end := start + 1; end < len(line); end++ {
+			if line[end] == '\'' && end+1 < len(line) && line[end+1] == '\'' {
+				end++
+				continue
+			}
+			if line[end] == '\'' {
+				return end + 1, true
+			}
+		}
+		return 0, false
+	case 0:
+		// Plain scalars end with a comment or the line, those spanning several lines are checked once edited
+		end := len(line)
+		if comment := strings.Index(line[start:], " #"); comment >= 0 {
+			end = start + comment
+		}
+		if indicator := strings.IndexAny(line[start:end], ",]}"); flow && indicator >= 0 {
+			end = start + indicator
+		}
+		end = start + len(strings.TrimRight(line[start:end], " \t"))
+		return end, end > start
+	}
+
+	return 0, false
+}
+
+// yamlNumber is a JSON number encoded to YAML as written
+type yamlNumber json.Number
+
+func (n yamlNumber) MarshalYAML() (interface{}, error) {
+	tag := "!!float"
+	if _, ok := new(big.Int).SetString(string(n), 10); ok {
+		tag = "!!int"
+	}
+
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: tag, Value: string(n)}, nil
+}
+
+// yamlNumbers replaces the JSON numbers of a decoded value so they are encoded as written
+func yamlNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		return yamlNumber(value)
+	case map[string]interface{}:
+		for key, item := range value {
+			value[key] = yamlNumbers(item)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = yamlNumbers(item)
+		}
+	}
+
+	return value
+}
+
+// getYAMLValue returns the compact JSON value of a key, and whether it exists
+func getYAMLValue(file string, keys []string) (string, bool, error) {
+	var document yaml.Node
+	err := yaml.Unmarshal([]byte(file), &document)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to parse YAML: %s", err)
+	}
+	if document.Kind == 0 {
+		return "", false, nil
+	}
+
+	node := document.Content[0]
+	for _, key := range keys {
+		for node.Kind == yaml.AliasNode {
+			node = node.Alias
+		}
+
+		switch node.Kind {
+		case yaml.MappingNode:
+			var child *yaml.Node
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					child = node.Content[i+1]
+				}
+			}
+			if child == nil {
+				return "", false, nil
+			}
+			node = child
+		case yaml.SequenceNode:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node.Content) {
+				return "", false, nil
+			}
+			node = node.Content[index]
+		default:
+			return "", false, nil
+		}
+	}
+
+	for node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	// Numbers that are valid JSON are returned as written, decoding would round large ones
+	if node.Kind == yaml.ScalarNode && (node.ShortTag() == "!!int" || node.ShortTag() == "!!float") {
+		if _, err := decodeJSONNumbers(node.Value); err == nil {
+			return node.Value, true, nil
+		}
+	}
+
+	var value interface{}
+	err = node.Decode(&value)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to decode value: %s", err)
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to encode value: %s", err)
+	}
+
+	return string(encoded), true, nil
+}
+
+// tomlEntry is a key/value pair of a TOML file along with the offsets its value spans
+type tomlEntry struct {
+	keys []string
+	// Keys of the table header the pair is written under
+	table []string
+	start int
+	end   int
+}
+
+// tomlDocument locates the key/value pairs and tables of a TOML file
+type tomlDocument struct {
+	entries []tomlEntry
+	// Offset of the end of the last line of each table by name, the root table is always present with -1 when empty
+	tables map[string]int
+	// Names of the arrays of tables, their keys cannot be set
+	arrays [][]string
+}
+
+var tomlBareKeyRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// encodeTOMLKey encodes a dotted key, quoting the segments that cannot be bare
+func encodeTOMLKey(keys []string) string {
+	segments := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if tomlBareKeyRegexp.MatchString(key) {
+			segments = append(segments, key)
+		} else {
+			encoded, _ := json.Marshal(key)
+			segments = append(segments, string(encoded))
+		}
+	}
+
+	return strings.Join(segments, ".")
+}
+
+func isTOMLBareKeyChar(c byte) bool {
+	return c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// hasKeyPrefix returns whether keys start with all of prefix
+func hasKeyPrefix(keys []string, prefix []string) bool {
+	if len(prefix) > len(keys) {
+		return false
+	}
+	for i := range prefix {
+		if keys[i] != prefix[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// parseTOMLDocument scans a TOML file for its key/value pairs and tables.
+// Values are only delimited, the file is not otherwise validated.
+func parseTOMLDocument(file string) (*tomlDocument, error) {
+	document := &tomlDocument{tables: map[string]int{"": -1}}
+	var table []string
+	array := false
+
+	pos := 0
+	for {
+		pos = skipTOMLSpace(file, pos, true)
+		if pos >= len(file) {
+			return document, nil
+		}
+
+		switch file[pos] {
+		case '#':
+			pos = tomlLineEnd(file, pos)
+			continue
+		case '[':
+			array = strings.HasPrefix(file[pos:], "[[")
+			pos++
+			if array {
+				pos++
+			}
+
+			keys, end, err := parseTOMLKey(file, pos)
+			if err != nil {
+				return nil, err
+			}
+			pos = skipTOMLSpace(file, end, false)
+
+			closing := "]"
+			if array {
+				closing = "]]"
+			}
+			if !strings.HasPrefix(file[pos:], closing) {
+				return nil, fmt.Errorf("missing %s after table %s", closing, encodeTOMLKey(keys))
+			}
+			pos += len(closing)
+
+			table = keys
+			if array {
+				document.arrays = append(document.arrays, keys)
+			}
+		default:
+			keys, end, err := parseTOMLKey(file, pos)
+			if err != nil {
+				return nil, err
+			}
+			pos = skipTOMLSpace(file, end, false)
+			if pos >= len(file) || file[pos] != '=' {
+				return nil, fmt.Errorf("missing = after key %s", encodeTOMLKey(keys))
+			}
+			start := skipTOMLSpace(file, pos+1, false)
+
+			end, err = scanTOMLValue(file, start)
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse value of key %s: %s", encodeTOMLKey(keys), err)
+			}
+			pos = end
+
+			entryKeys := append(append([]string{}, table...), keys...)
+			document.entries = append(document.entries, tomlEntry{keys: entryKeys, table: table, start: start, end: end})
+		}
+
+		// Only a comment can follow on the same line
+		pos = skipTOMLSpace(file, pos, false)
+		if pos < len(file) && file[pos] != '#' && file[pos] != '\n' && file[pos] != '\r' {
+			return nil, fmt.Errorf("unexpected %q at offset %d", file[pos], pos)
+		}
+		pos = tomlLineEnd(file, pos)
+
+		if !array {
+			document.tables[encodeTOMLKey(table)] = pos
+		}
+	}
+}
+
+// skipTOMLSpace returns the offset of the next character that is not a space, nor a line break when lines is set
+func skipTOMLSpace(file string, pos int, lines bool) int {
+	for pos < len(file) && (file[pos] == ' ' || file[pos] == '\t' || (lines && (file[pos] == '\n' || file[pos] == '\r'))) {
+		pos++
+	}
+
+	return pos
+}
+
+// tomlLineEnd returns the offset of the line break ending the line of an offset, or the length of the file
+func tomlLineEnd(file string, pos int) int {
+	if end := strings.IndexByte(file[pos:], '\n'); end >= 0 {
+		return pos + end
+	}
+
+	return len(file)
+}
+
+// parseTOMLKey parses a dotted key made of bare and quoted segments, returning its end offset
+func parseTOMLKey(file string, pos int) ([]string, int, error) {
+	var keys []string
+
+	for {
+		pos = skipTOMLSpace(file, pos, false)
+		if pos >= len(file) {
+			return nil, 0, fmt.Errorf("missing key at end of file")
+		}
+
+		switch file[pos] {
+		case '"', '\'':
+			end, err := scanTOMLString(file, pos)
+			if err != nil {
+				return nil, 0, err
+			}
+			key := file[pos+1 : end-1]
+			if file[pos] == '"' {
+				key, err = strconv.Unquote(file[pos:end])
+				if err != nil {
+					return nil, 0, fmt.Errorf("invalid key %s: %s", file[pos:end], err)
+				}
+			}
+			keys = append(keys, key)
+			pos = end
+		default:
+			end := pos
+			for end < len(file) && isTOMLBareKeyChar(file[end]) {
+				end++
+			}
+			if end == pos {
+				return nil, 0, fmt.Errorf("unexpected %q at offset %d", file[pos], pos)
+			}
+			keys = append(keys, file[pos:end])
+			pos = end
+		}
+
+		pos = skipTOMLSpace(file, pos, false)
+		if pos >= len(file) || file[pos] != '.' {
+			return keys, pos, nil
+		}
+		pos++
+	}
+}
+
+// scanTOMLString returns the end offset of the single or multi-line string starting at an offset
+func scanTOMLString(file string, pos int) (int, error) {
+	quote := file[pos : pos+1]
+	if strings.HasPrefix(file[pos:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+
+	for end := pos + len(quote); end < len(file); end++ {
+		if file[end] == '\\' && quote[0] == '"' {
+			end++
+			continue
+		}
+		if file[end] == '\n' && len(quote) == 1 {
+			break
+		}
+		if strings.HasPrefix(file[end:], quote) {
+			end += len(quote)
+			// Multi-line strings may end with up to two quotes of their content
+			for i := 0; i < 2 && len(quote) == 3 && end < len(file) && file[end] == quote[0]; i++ {
+				end++
+			}
+			return end, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unterminated string")
+}
+
+// scanTOMLValue returns the end offset of the value starting at an offset, arrays and strings may span several lines
+func scanTOMLValue(file string, pos int) (int, error) {
+	if pos >= len(file) {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	switch file[pos] {
+	case '"', '\'':
+		return scanTOMLString(file, pos)
+	case '[', '{':
+		depth := 0
+		for pos < len(file) {
+			switch file[pos] {
+			case '"', '\'':
+				end, err := scanTOMLString(file, pos)
+				if err != nil {
+					return 0, err
+				}
+				pos = end
+				continue
+			case '#':
+				pos = tomlLineEnd(file, pos)
+				continue
+			case '[', '{':
+				depth++
+			case ']', '}':
+				depth--
+				if depth == 0 {
+					return pos + 1, nil
+				}
+			}
+			pos++
+		}
+		return 0, fmt.Errorf("unterminated array or inline table")
+	}
+
+	// Numbers, booleans and dates end with their line or a comment
+	end := tomlLineEnd(file, pos)
+	if comment := strings.IndexByte(file[pos:end], '#'); comment >= 0 {
+		end = pos + comment
+	}
+	value := strings.TrimRight(file[pos:end], " \t\r")
+	if value == "" {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	return pos + len(value), nil
+}
+
+// setTOMLValue sets the value of a dotted key, only editing the span of its value
+func setTOMLValue(file string, keys []string, value interface{}) (string, error) {
+	encoded, err := encodeTOMLValue(value)
+	if err != nil {
+		return "", err
+	}
+
+	document, err := parseTOMLDocument(file)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse TOML: %s", err)
+	}
+
+	for _, array := range document.arrays {
+		if hasKeyPrefix(keys, array) {
+			return "", fmt.Errorf("key %s is within the array of tables %s, which is not supported", encodeTOMLKey(keys), encodeTOMLKey(array))
+		}
+	}
+
+	var edited string
+	var sibling *tomlEntry
+	for i, entry := range document.entries {
+		switch {
+		case len(entry.keys) == len(keys) && hasKeyPrefix(keys, entry.keys):
+			// Datetimes are written unquoted, changing them to a string would change their type
+			if isTOMLDatetime(file[entry.start:entry.end]) {
+				text, ok := value.(string)
+				if !ok || !isTOMLDatetime(text) {
+					return "", fmt.Errorf("key %s holds a datetime, its value has to be a TOML datetime", encodeTOMLKey(keys))
+				}
+				encoded = text
+			}
+			edited = file[:entry.start] + encoded + file[entry.end:]
+		case hasKeyPrefix(keys, entry.keys):
+			return "", fmt.Errorf("key %s is within the value of key %s, which is not supported", encodeTOMLKey(keys), encodeTOMLKey(entry.keys))
+		case hasKeyPrefix(entry.keys, keys):
+			return "", fmt.Errorf("key %s is a table", encodeTOMLKey(keys))
+		case hasKeyPrefix(entry.keys, keys[:len(keys)-1]) && len(entry.table) < len(keys):
+			// The table of the key may only be defined by the dotted keys of its other pairs
+			sibling = &document.entries[i]
+		}
+	}
+	if _, ok := document.tables[encodeTOMLKey(keys)]; ok && len(keys) > 0 {
+		return "", fmt.Errorf("key %s is a table", encodeTOMLKey(keys))
+	}
+
+	// Add the key to its table, creating the table at the end of the file when needed
+	if edited == "" {
+		table := encodeTOMLKey(keys[:len(keys)-1])
+		line := fmt.Sprintf("%s = %s", encodeTOMLKey(keys[len(keys)-1:]), encoded)
+
+		end, found := document.tables[table]
+		switch {
+		case !found && sibling != nil:
+			// Tables defined by dotted keys cannot get a header, the key is added after its last sibling instead
+			end := tomlLineEnd(file, sibling.end)
+			line = fmt.Sprintf("%s = %s", encodeTOMLKey(keys[len(sibling.table):]), encoded)
+			edited = file[:end] + "\n" + line + file[end:]
+		case found && end < 0:
+			edited = line + "\n" + file
+		case found:
+			edited = file[:end] + "\n" + line + file[end:]
+		default:
+			edited = file
+			if edited != "" && !strings.HasSuffix(edited, "\n") {
+				edited += "\n"
+			}
+			if edited != "" {
+				edited += "\n"
+			}
+			edited += fmt.Sprintf("[%s]\n%s\n", table, line)
+		}
+	}
+
+	// The scan only delimits values, the edited file is checked by a parser
+	var decoded map[string]interface{}
+	if _, err := toml.Decode(edited, &decoded); err != nil {
+		return "", fmt.Errorf("failed to set key %s: the file would not be valid TOML: %s", encodeTOMLKey(keys), err)
+	}
+
+	return edited, nil
+}
+
+// isTOMLDatetime returns whether a value is an offset or local datetime, date or time
+func isTOMLDatetime(value string) bool {
+	var decoded map[string]interface{}
+	if _, err := toml.Decode("value = "+value, &decoded); err != nil {
+		return false
+	}
+
+	_, ok := decoded["value"].(time.Time)
+	return ok
+}
+
+// formatTOMLDatetime formats a decoded datetime like TOML writes it, local ones without the missing parts
+func formatTOMLDatetime(value time.Time) string {
+	switch value.Location().String() {
+	case "datetime-local":
+		return value.Format("2006-01-02T15:04:05.999999999")
+	case "date-local":
+		return value.Format("2006-01-02")
+	case "time-local":
+		return value.Format("15:04:05.999999999")
+	}
+
+	return value.Format(time.RFC3339Nano)
+}
+
+// getTOMLValue returns the compact JSON value of a dotted key, and whether it exists
+func getTOMLValue(file string, keys []string) (string, bool, error) {
+	var root map[string]interface{}
+	if _, err := toml.Decode(file, &root); err != nil {
+		return "", false, fmt.Errorf("failed to parse TOML: %s", err)
+	}
+
+	var node interface{} = root
+	for i, key := range keys {
+		switch current := node.(type) {
+		case map[string]interface{}:
+			child, ok := current[key]
+			if !ok {
+				return "", false, nil
+			}
+			node = child
+		case []map[string]interface{}:
+			return "", false, fmt.Errorf("key %s is within the array of tables %s, which is not supported", encodeTOMLKey(keys), encodeTOMLKey(keys[:i]))
+		default:
+			return "", false, nil
+		}
+	}
+
+	encoded, err := json.Marshal(formatTOMLDatetimes(node))
+	if err != nil {
+		return "", false, fmt.Errorf("failed to encode value: %s", err)
+	}
+
+	return string(encoded), true, nil
+}
+
+// formatTOMLDatetimes replaces the datetimes of a decoded value by their TOML text
+func formatTOMLDatetimes(value interface{}) interface{} {
+	switch value := value.(type) {
+	case time.Time:
+		return formatTOMLDatetime(value)
+	case map[string]interface{}:
+		formatted := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			formatted[key] = formatTOMLDatetimes(item)
+		}
+		return formatted
+	case []interface{}:
+		formatted := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			formatted = append(formatted, formatTOMLDatetimes(item))
+		}
+		return formatted
+	case []map[string]interface{}:
+		formatted := make([]interface{}, 0, len(value))
+		for _, item := range value {
+			formatted = append(formatted, formatTOMLDatetimes(item))
+		}
+		return formatted
+	}
+
+	return value
+}
+
+func encodeTOMLValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case nil:
+		return "", fmt.Errorf("TOML has no null value")
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var items []string
+		for _, key := range keys {
+			encoded, err := encodeTOMLValue(value[key])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, fmt.Sprintf("%s = %s", encodeTOMLKey([]string{key}), encoded))
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	case []interface{}:
+		var items []string
+		for _, item := range value {
+			encoded, err := encodeTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, encoded)
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	}
+
+	// Numbers are decoded as json.Number and kept as written
+	encoded, err := json.Marshal(value)
+	return string(encoded), err
+}
@@ -0,0 +1,234 @@
+package provider
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSetYAMLValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		key      string
+		value    interface{}
+		expected string
+	}{
+		{
+			name:     "multiple documents with comments",
+			file:     "# top\na:\n  b: 1 # keep\n---\nkind: Second\nx: 2\n",
+			key:      "a.b",
+			value:    "v2",
+			expected: "# top\na:\n  b: v2 # keep\n---\nkind: Second\nx: 2\n",
+		},
+		{
+			name:     "blank lines and quoting",
+			file:     "a:\n\n  b: 1 # keep\n\nc: 'q' # k\n---\nkind: Second\n\nx: 2\n",
+			key:      "c",
+			value:    "z",
+			expected: "a:\n\n  b: 1 # keep\n\nc: 'z' # k\n---\nkind: Second\n\nx: 2\n",
+		},
+		{
+			name:     "missing key in the first of several documents",
+			file:     "a:\n  b: 1\nc: 2\n---\n# second\nkind: Second\n",
+			key:      "a.d",
+			value:    json.Number("3"),
+			expected: "a:\n  b: 1\n  d: 3\nc: 2\n---\n# second\nkind: Second\n",
+		},
+		{
+			name:     "flow sequence",
+			file:     "list: [a, b]\n\nz: 1\n",
+			key:      "list.0",
+			value:    "c",
+			expected: "list: [c, b]\n\nz: 1\n",
+		},
+		{
+			name:     "document markers",
+			file:     "---\na: 1\n...\n---\nb: 2\n",
+			key:      "a",
+			value:    json.Number("9007199254740993"),
+			expected: "---\na: 9007199254740993\n...\n---\nb: 2\n",
+		},
+		{
+			name:     "comment only",
+			file:     "# only a comment\n",
+			key:      "a",
+			value:    true,
+			expected: "# only a comment\na: true\n",
+		},
+		{
+			name:     "alias",
+			file:     "base: &b\n  x: 1\nother: *b\n",
+			key:      "other.x",
+			value:    json.Number("2"),
+			expected: "base: &b\n  x: 2\nother: *b\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			edited, err := setYAMLValue(test.file, strings.Split(test.key, "."), test.value)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if edited != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, edited)
+			}
+		})
+	}
+}
+
+func TestGetYAMLValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		key      string
+		expected string
+		found    bool
+	}{
+		{name: "large integer", file: "a: 9007199254740993\n", key: "a", expected: "9007199254740993", found: true},
+		{name: "first document only", file: "a: 1\n---\nb: 2\n", key: "b", found: false},
+		{name: "mapping", file: "a:\n  b: [1, x]\n", key: "a", expected: `{"b":[1,"x"]}`, found: true},
+		{name: "empty", file: "", key: "a", found: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, found, err := getYAMLValue(test.file, strings.Split(test.key, "."))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if found != test.found || value != test.expected {
+				t.Errorf("expected %q (%t), got %q (%t)", test.expected, test.found, value, found)
+			}
+		})
+	}
+}
+
+func TestSetTOMLValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		key      string
+		value    interface{}
+		expected string
+		err      string
+	}{
+		{
+			name:     "offset datetime",
+			file:     "d = 1979-05-27T07:32:00Z # c\n",
+			key:      "d",
+			value:    "2000-01-01T00:00:00Z",
+			expected: "d = 2000-01-01T00:00:00Z # c\n",
+		},
+		{
+			name:     "local date",
+			file:     "d = 1979-05-27\n",
+			key:      "d",
+			value:    "2000-01-02",
+			expected: "d = 2000-01-02\n",
+		},
+		{
+			name:  "datetime set to a string",
+			file:  "d = 1979-05-27\n",
+			key:   "d",
+			value: "tomorrow",
+			err:   "key d holds a datetime",
+		},
+		{
+			name:     "table defined by dotted keys",
+			file:     "a.b = 1\n",
+			key:      "a.c",
+			value:    json.Number("2"),
+			expected: "a.b = 1\na.c = 2\n",
+		},
+		{
+			name:     "dotted keys within a table",
+			file:     "x = 1\n\n[t]\na.b = [\n  1,\n]\n\n[other]\ny = 2\n",
+			key:      "t.a.c",
+			value:    "v",
+			expected: "x = 1\n\n[t]\na.b = [\n  1,\n]\na.c = \"v\"\n\n[other]\ny = 2\n",
+		},
+		{
+			name:     "parent of a table header",
+			file:     "[a.b]\nx = 1\n",
+			key:      "a.c",
+			value:    "v",
+			expected: "[a.b]\nx = 1\n\n[a]\nc = \"v\"\n",
+		},
+		{
+			name:     "multi-line array",
+			file:     "list = [\n  1, # one\n  2,\n]\nz = 'keep'\n",
+			key:      "list",
+			value:    []interface{}{json.Number("3")},
+			expected: "list = [3]\nz = 'keep'\n",
+		},
+		{
+			name:     "quoted key",
+			file:     "[tool.\"my app\"]\nversion = \"1\"\n",
+			key:      "tool.my app.version",
+			value:    "2",
+			expected: "[tool.\"my app\"]\nversion = \"2\"\n",
+		},
+		{
+			name:  "array of tables",
+			file:  "[[items]]\nname = \"a\"\n",
+			key:   "items.name",
+			value: "b",
+			err:   "within the array of tables items",
+		},
+		{
+			name:  "inline table",
+			file:  "a = { b = 1 }\n",
+			key:   "a.b",
+			value: json.Number("2"),
+			err:   "within the value of key a",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			edited, err := setTOMLValue(test.file, strings.Split(test.key, "."), test.value)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error %q, got %v", test.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if edited != test.expected {
+				t.Errorf("expected %q, got %q", test.expected, edited)
+			}
+		})
+	}
+}
+
+func TestGetTOMLValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		key      string
+		expected string
+	}{
+		{name: "offset datetime", file: "d = 1979-05-27T07:32:00-07:00", key: "d", expected: `"1979-05-27T07:32:00-07:00"`},
+		{name: "local datetime", file: "d = 1979-05-27T07:32:00", key: "d", expected: `"1979-05-27T07:32:00"`},
+		{name: "local date", file: "d = 1979-05-27", key: "d", expected: `"1979-05-27"`},
+		{name: "local time", file: "d = 07:32:00.5", key: "d", expected: `"07:32:00.5"`},
+		{name: "datetimes in an array", file: "d = [1979-05-27]", key: "d", expected: `["1979-05-27"]`},
+		{name: "dotted keys", file: "a.b = 1\n", key: "a", expected: `{"b":1}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, found, err := getTOMLValue(test.file, strings.Split(test.key, "."))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !found || value != test.expected {
+				t.Errorf("expected %s, got %s (%t)", test.expected, value, found)
+			}
+		})
+	}
+}